@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/config"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/db"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/migrate"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would change without writing")
+	flag.Parse()
+
+	cfg := config.Load()
+
+	client, err := db.New(cfg.MongoURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	rep, err := migrate.UserIDs(ctx, client.Database(cfg.MongoDB), *dryRun)
+	if rep != nil {
+		log.Printf("user ids: %s", rep)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dryRun {
+		log.Println("dry run, nothing written")
+	}
+}
@@ -10,6 +10,8 @@ import (
 
 	"github.com/saurabhraut1212/ecommerce_backend/internal/config"
 	"github.com/saurabhraut1212/ecommerce_backend/internal/db"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/jwtkeys"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/mailer"
 	"github.com/saurabhraut1212/ecommerce_backend/internal/router"
 )
 
@@ -21,7 +23,24 @@ func main() {
 		log.Fatal(err)
 	}
 
-	app := router.New(cfg, client)
+	mail, err := mailer.New(cfg.MailOutput)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keys, err := jwtkeys.Load(jwtkeys.Config{
+		Secret:         cfg.JWTSecret,
+		SigningKeyFile: cfg.JWTSigningKeyFile,
+		SigningKeyID:   cfg.JWTSigningKeyID,
+		VerifyKeyFiles: cfg.JWTVerifyKeyFiles,
+
+		AcceptLegacyHS256: cfg.JWTAcceptHS256,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app := router.New(cfg, client, mail, keys)
 
 	// Channel to listen for OS signals
 	done := make(chan os.Signal, 1)
@@ -3,6 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,16 +15,85 @@ type Config struct {
 	MongoURI  string
 	MongoDB   string
 	JWTSecret string
+
+	JWTSigningKeyFile string   // PEM RSA or Ed25519 private key; HS256 with JWTSecret when empty
+	JWTSigningKeyID   string   // kid header, defaults to the key thumbprint
+	JWTVerifyKeyFiles []string // extra "kid=path" public keys still accepted after a rotation
+	JWTAcceptHS256    bool     // keep accepting JWTSecret tokens alongside a signing key
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	AppBaseURL       string // where emailed links point, e.g. the storefront
+	MailOutput       string // file the log mailer appends to; stdout when empty
+	PasswordResetTTL time.Duration
+
+	EmailVerifyTTL       time.Duration
+	RequireVerifiedEmail bool // block order placement until the email is verified
+
+	// Login throttling: failures are counted over LoginWindow; hitting a
+	// MaxFailures limit locks that email or IP for LoginLockout. Below the
+	// limit each failure doubles the wait, from LoginDelayBase up to LoginDelayMax.
+	LoginWindow              time.Duration
+	LoginMaxFailuresPerEmail int
+	LoginMaxFailuresPerIP    int
+	LoginLockout             time.Duration
+	LoginDelayBase           time.Duration
+	LoginDelayMax            time.Duration
+
+	TOTPIssuer  string // shown in authenticator apps
+	MFATokenTTL time.Duration
+
+	OIDCProviders []OIDCProvider
+}
+
+// OIDCProvider is read from OIDC_<NAME>_* variables for every name listed in
+// OIDC_PROVIDERS.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
 }
 
 func Load() *Config {
 	_ = godotenv.Load()
 
+	port := getEnv("PORT", "8080")
 	return &Config{
-		Port:      getEnv("PORT", "8080"),
+		Port:      port,
 		MongoURI:  mustEnv("MONGO_URI"),
 		MongoDB:   getEnv("MONGO_DB", "ecommerce"),
-		JWTSecret: mustEnv("JWT_SECRET"),
+		JWTSecret: getEnv("JWT_SECRET", ""),
+
+		JWTSigningKeyFile: getEnv("JWT_SIGNING_KEY_FILE", ""),
+		JWTSigningKeyID:   getEnv("JWT_SIGNING_KEY_ID", ""),
+		JWTVerifyKeyFiles: getList("JWT_VERIFY_KEY_FILES"),
+		JWTAcceptHS256:    getBool("JWT_ACCEPT_LEGACY_HS256", false),
+
+		AccessTokenTTL:  getDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		AppBaseURL:       getEnv("APP_BASE_URL", "http://localhost:"+port),
+		MailOutput:       getEnv("MAIL_OUTPUT", ""),
+		PasswordResetTTL: getDuration("PASSWORD_RESET_TTL", time.Hour),
+
+		EmailVerifyTTL:       getDuration("EMAIL_VERIFY_TTL", 48*time.Hour),
+		RequireVerifiedEmail: getBool("REQUIRE_VERIFIED_EMAIL", false),
+
+		LoginWindow:              getDuration("LOGIN_WINDOW", 15*time.Minute),
+		LoginMaxFailuresPerEmail: getInt("LOGIN_MAX_FAILURES_PER_EMAIL", 5),
+		LoginMaxFailuresPerIP:    getInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		LoginLockout:             getDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		LoginDelayBase:           getDuration("LOGIN_DELAY_BASE", time.Second),
+		LoginDelayMax:            getDuration("LOGIN_DELAY_MAX", 30*time.Second),
+
+		TOTPIssuer:  getEnv("TOTP_ISSUER", "Ecommerce"),
+		MFATokenTTL: getDuration("MFA_TOKEN_TTL", 5*time.Minute),
+
+		OIDCProviders: loadOIDCProviders(port),
 	}
 }
 
@@ -42,3 +114,67 @@ func mustEnv(k string) string {
 	return v
 
 }
+
+func getDuration(k string, d time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	dur, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid duration for env %s: %v", k, err)
+	}
+	return dur
+}
+
+func getBool(k string, d bool) bool {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("invalid boolean for env %s: %v", k, err)
+	}
+	return b
+}
+
+func getInt(k string, d int) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid integer for env %s: %v", k, err)
+	}
+	return n
+}
+
+// getList splits a comma separated env var, dropping empty entries.
+func getList(k string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(k), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func loadOIDCProviders(port string) []OIDCProvider {
+	var out []OIDCProvider
+	for _, name := range getList("OIDC_PROVIDERS") {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		out = append(out, OIDCProvider{
+			Name:         name,
+			Issuer:       mustEnv(prefix + "ISSUER"),
+			ClientID:     mustEnv(prefix + "CLIENT_ID"),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", "http://localhost:"+port+"/api/auth/oidc/"+name+"/callback"),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+		})
+	}
+	return out
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/middleware"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxSavedAddresses = 50
+
+// addressInput is a postal address in a request body.
+type addressInput struct {
+	Name       string `json:"name" validate:"required,max=100"`
+	Line1      string `json:"line1" validate:"required,max=200"`
+	Line2      string `json:"line2" validate:"max=200"`
+	City       string `json:"city" validate:"required,max=100"`
+	State      string `json:"state" validate:"max=100"`
+	PostalCode string `json:"postal_code" validate:"required,max=20"`
+	Country    string `json:"country" validate:"required,country"`
+	Phone      string `json:"phone" validate:"max=30"`
+}
+
+func (in addressInput) toModel() models.Address {
+	return models.Address{
+		Name:       in.Name,
+		Line1:      in.Line1,
+		Line2:      in.Line2,
+		City:       in.City,
+		State:      in.State,
+		PostalCode: in.PostalCode,
+		Country:    strings.ToUpper(in.Country),
+		Phone:      in.Phone,
+	}
+}
+
+// AddressHandler serves the logged-in user's address book at /api/me/addresses.
+type AddressHandler struct {
+	Addresses *repo.AddressRepo
+}
+
+func NewAddressHandler(addresses *repo.AddressRepo) *AddressHandler {
+	return &AddressHandler{
+		Addresses: addresses,
+	}
+}
+
+func (h *AddressHandler) List(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	items, err := h.Addresses.ListByUser(ctx, middleware.GetClaims(c).UserID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(items)
+}
+
+// Create adds an address. The first address becomes the default for both
+// shipping and billing.
+func (h *AddressHandler) Create(c *fiber.Ctx) error {
+	var req struct {
+		Label string `json:"label" validate:"max=50"`
+		addressInput
+		DefaultShipping bool `json:"default_shipping"`
+		DefaultBilling  bool `json:"default_billing"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+	uid := middleware.GetClaims(c).UserID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := h.Addresses.CountByUser(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n >= maxSavedAddresses {
+		return c.Status(409).JSON(fiber.Map{"error": "address book is full"})
+	}
+
+	a := &models.SavedAddress{
+		UserID:          uid,
+		Label:           req.Label,
+		Address:         req.toModel(),
+		DefaultShipping: req.DefaultShipping || n == 0,
+		DefaultBilling:  req.DefaultBilling || n == 0,
+	}
+	if err := h.Addresses.Create(ctx, a); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.clearOtherDefaults(ctx, a); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(a)
+}
+
+func (h *AddressHandler) Get(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a, err := h.Addresses.Get(ctx, middleware.GetClaims(c).UserID, oid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if a == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(a)
+}
+
+func (h *AddressHandler) Update(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	// nil fields were absent from the body and are left unchanged
+	var req struct {
+		Label           *string `json:"label" validate:"omitempty,max=50"`
+		Name            *string `json:"name" validate:"omitempty,min=1,max=100"`
+		Line1           *string `json:"line1" validate:"omitempty,min=1,max=200"`
+		Line2           *string `json:"line2" validate:"omitempty,max=200"`
+		City            *string `json:"city" validate:"omitempty,min=1,max=100"`
+		State           *string `json:"state" validate:"omitempty,max=100"`
+		PostalCode      *string `json:"postal_code" validate:"omitempty,min=1,max=20"`
+		Country         *string `json:"country" validate:"omitempty,country"`
+		Phone           *string `json:"phone" validate:"omitempty,max=30"`
+		DefaultShipping *bool   `json:"default_shipping"`
+		DefaultBilling  *bool   `json:"default_billing"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+	update := bson.M{}
+	for field, val := range map[string]*string{
+		"label": req.Label, "name": req.Name, "line1": req.Line1, "line2": req.Line2,
+		"city": req.City, "state": req.State, "postal_code": req.PostalCode, "phone": req.Phone,
+	} {
+		if val != nil {
+			update[field] = *val
+		}
+	}
+	if req.Country != nil {
+		update["country"] = strings.ToUpper(*req.Country)
+	}
+	if req.DefaultShipping != nil {
+		update[repo.DefaultShipping] = *req.DefaultShipping
+	}
+	if req.DefaultBilling != nil {
+		update[repo.DefaultBilling] = *req.DefaultBilling
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a, err := h.Addresses.Update(ctx, middleware.GetClaims(c).UserID, oid, update)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if a == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err := h.clearOtherDefaults(ctx, a); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(a)
+}
+
+func (h *AddressHandler) Delete(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Addresses.Delete(ctx, middleware.GetClaims(c).UserID, oid); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.SendStatus(204)
+}
+
+// clearOtherDefaults keeps a the only default for the flags it has set.
+func (h *AddressHandler) clearOtherDefaults(ctx context.Context, a *models.SavedAddress) error {
+	if a.DefaultShipping {
+		if err := h.Addresses.ClearDefault(ctx, a.UserID, a.ID, repo.DefaultShipping); err != nil {
+			return err
+		}
+	}
+	if a.DefaultBilling {
+		return h.Addresses.ClearDefault(ctx, a.UserID, a.ID, repo.DefaultBilling)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/middleware"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AdminUserHandler serves /api/admin/users.
+type AdminUserHandler struct {
+	Users     *repo.UserRepo
+	Passwords *PasswordHandler
+	sessions  *sessionStore
+}
+
+func NewAdminUserHandler(users *repo.UserRepo, refresh *repo.RefreshTokenRepo, sessions *repo.SessionRepo, passwords *PasswordHandler) *AdminUserHandler {
+	return &AdminUserHandler{
+		Users:     users,
+		Passwords: passwords,
+		sessions:  &sessionStore{records: sessions, refresh: refresh},
+	}
+}
+
+// List pages through users, optionally filtered by ?q= on name or email.
+func (h *AdminUserHandler) List(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	users, total, err := h.Users.List(ctx, c.Query("q"), page, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": users, "total": total, "page": page, "limit": limit})
+}
+
+func (h *AdminUserHandler) Get(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.Users.FindByID(ctx, oid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(user)
+}
+
+func (h *AdminUserHandler) SetRole(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	var req struct {
+		Role string `json:"role" validate:"required,oneof=customer admin"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+	if oid == middleware.GetClaims(c).UserID && req.Role != models.RoleAdmin {
+		return c.Status(409).JSON(fiber.Map{"error": "you cannot remove your own admin role"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Users.SetRole(ctx, oid, req.Role); err != nil {
+		return h.updateError(c, err)
+	}
+	return h.Get(c)
+}
+
+// Disable blocks the account from logging in and signs it out everywhere.
+func (h *AdminUserHandler) Disable(c *fiber.Ctx) error {
+	return h.setDisabled(c, true)
+}
+
+func (h *AdminUserHandler) Enable(c *fiber.Ctx) error {
+	return h.setDisabled(c, false)
+}
+
+func (h *AdminUserHandler) setDisabled(c *fiber.Ctx, disabled bool) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	if disabled && oid == middleware.GetClaims(c).UserID {
+		return c.Status(409).JSON(fiber.Map{"error": "you cannot disable your own account"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Users.SetDisabled(ctx, oid, disabled); err != nil {
+		return h.updateError(c, err)
+	}
+	if disabled {
+		if err := h.sessions.revokeAll(ctx, oid, primitive.NilObjectID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	return h.Get(c)
+}
+
+// ForcePasswordReset rejects the current password, signs the user out and
+// mails them a reset link.
+func (h *AdminUserHandler) ForcePasswordReset(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	user, err := h.Users.FindByID(ctx, oid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err := h.Users.RequirePasswordReset(ctx, oid); err != nil {
+		return h.updateError(c, err)
+	}
+	if err := h.sessions.revokeAll(ctx, oid, primitive.NilObjectID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.Passwords.sendReset(ctx, user); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(202).JSON(fiber.Map{"message": "password reset required, link sent to " + user.Email})
+}
+
+func (h *AdminUserHandler) updateError(c *fiber.Ctx, err error) error {
+	switch err {
+	case mongo.ErrNoDocuments:
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	case repo.ErrLastAdmin:
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
@@ -2,36 +2,66 @@ package handlers
 
 import (
 	"context"
+	"log"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/config"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/jwtkeys"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/mailer"
 	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
 	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
-	UserRepo  *repo.UserRepo
-	JWTSecret string
+	UserRepo        *repo.UserRepo
+	RefreshTokens   *repo.RefreshTokenRepo
+	OneTimeTokens   *repo.OneTimeTokenRepo
+	Orders          *repo.OrderRepo
+	Keys            *jwtkeys.KeySet
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	EmailVerifyTTL  time.Duration
+	MFATokenTTL     time.Duration
+	TOTPIssuer      string
+	Guard           *LoginGuard
+	links           *linkMailer
+	sessions        *sessionStore
 }
 
-func NewAuthHandler(userRepo *repo.UserRepo, jwtSecret string) *AuthHandler {
+func NewAuthHandler(userRepo *repo.UserRepo, refreshRepo *repo.RefreshTokenRepo, oneTimeRepo *repo.OneTimeTokenRepo, sessionRepo *repo.SessionRepo, orderRepo *repo.OrderRepo, guard *LoginGuard, keys *jwtkeys.KeySet, m mailer.Mailer, cfg *config.Config) *AuthHandler {
 	return &AuthHandler{
-		UserRepo:  userRepo,
-		JWTSecret: jwtSecret,
+		UserRepo:        userRepo,
+		RefreshTokens:   refreshRepo,
+		OneTimeTokens:   oneTimeRepo,
+		Orders:          orderRepo,
+		Keys:            keys,
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+		EmailVerifyTTL:  cfg.EmailVerifyTTL,
+		MFATokenTTL:     cfg.MFATokenTTL,
+		TOTPIssuer:      cfg.TOTPIssuer,
+		Guard:           guard,
+		links:           &linkMailer{tokens: oneTimeRepo, mailer: m, baseURL: strings.TrimRight(cfg.AppBaseURL, "/")},
+		sessions:        &sessionStore{records: sessionRepo, refresh: refreshRepo},
 	}
 }
 
 func (h *AuthHandler) Register(c *fiber.Ctx) error {
 	req := struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name     string `json:"name" validate:"required,max=100"`
+		Email    string `json:"email" validate:"required,email,max=254"`
+		Password string `json:"password" validate:"required,min=8,max=72"` // bcrypt ignores bytes past 72
 	}{}
 
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
 	}
 
 	hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
@@ -40,38 +70,298 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		Name:         req.Name,
 		Email:        req.Email,
 		PasswordHash: string(hash),
+		Role:         models.RoleCustomer,
 	}
 
-	if err := h.UserRepo.Create(context.Background(), user); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	if err := h.UserRepo.Create(ctx, user); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
-	return c.JSON(fiber.Map{"message": "user registered successfully"})
+	// the account exists either way; a lost email can be re-sent
+	if err := h.sendVerification(ctx, user); err != nil {
+		log.Printf("email verification for %s: %v", user.Email, err)
+	}
+	return c.JSON(fiber.Map{"message": "user registered successfully, check your email to verify the address"})
+}
+
+// VerifyEmail consumes the token from the verification email.
+func (h *AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "token required"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t, err := h.OneTimeTokens.Consume(ctx, hashToken(token), models.PurposeEmailVerify)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if t == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+	if err := h.UserRepo.MarkEmailVerified(ctx, t.UserID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user, err := h.UserRepo.FindByID(ctx, t.UserID); err != nil {
+		log.Printf("claim guest orders for %s: %v", t.UserID.Hex(), err)
+	} else if user != nil {
+		h.claimGuestOrders(ctx, user.ID, user.Email)
+	}
+	return c.JSON(fiber.Map{"message": "email verified"})
+}
+
+// claimGuestOrders moves guest orders placed with email into the user's
+// account. Callers must have just proven the user owns email. Failures are
+// only logged; the orders stay reachable by their lookup tokens and are
+// picked up on the next verified login.
+func (h *AuthHandler) claimGuestOrders(ctx context.Context, uid primitive.ObjectID, email string) {
+	n, err := h.Orders.ClaimGuestOrders(ctx, guestEmail(email), uid)
+	if err != nil {
+		log.Printf("claim guest orders for %s: %v", uid.Hex(), err)
+		return
+	}
+	if n > 0 {
+		log.Printf("attached %d guest orders to user %s", n, uid.Hex())
+	}
+}
+
+// ResendVerification mails a fresh verification link to the logged-in user.
+func (h *AuthHandler) ResendVerification(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	user, _, err := h.currentUser(ctx, c)
+	if err != nil || user == nil {
+		return err
+	}
+	if user.EmailVerified {
+		return c.Status(409).JSON(fiber.Map{"error": "email already verified"})
+	}
+	if err := h.sendVerification(ctx, user); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(202).JSON(fiber.Map{"message": "verification email sent"})
+}
+
+func (h *AuthHandler) sendVerification(ctx context.Context, user *models.User) error {
+	return h.links.send(ctx, link{
+		userID:  user.ID,
+		to:      user.Email,
+		purpose: models.PurposeEmailVerify,
+		ttl:     h.EmailVerifyTTL,
+		path:    "/api/verify-email",
+		subject: "Verify your email address",
+		intro:   "Welcome! Please confirm this is your email address.",
+	})
 }
 
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	req := struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" validate:"required,email"`
+		Password string `json:"password" validate:"required"`
 	}{}
 
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
 	}
-	user, err := h.UserRepo.FindByEmail(context.Background(), req.Email)
 
-	if err != nil || user == nil {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid credentials"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ip := c.IP()
+	wait, err := h.Guard.Wait(ctx, req.Email, ip)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if wait > 0 {
+		secs := int(math.Ceil(wait.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(secs))
+		return c.Status(429).JSON(fiber.Map{"error": "too many failed login attempts, try again later", "retry_after": secs})
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+	user, err := h.UserRepo.FindByEmail(ctx, req.Email)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		if err := h.Guard.Fail(ctx, req.Email, ip); err != nil {
+			log.Printf("record failed login: %v", err)
+		}
 		return c.Status(400).JSON(fiber.Map{"error": "invalid credentials"})
 	}
+	if msg := loginBlocked(user); msg != "" {
+		return c.Status(403).JSON(fiber.Map{"error": msg})
+	}
+	if user.TOTPEnabled {
+		return h.mfaChallenge(c, user)
+	}
+	if err := h.Guard.Succeed(ctx, req.Email); err != nil {
+		log.Printf("clear failed logins: %v", err)
+	}
+
+	// each login starts a new session and token family
+	tokens, err := h.startSession(ctx, c, user)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(tokens)
+}
+
+// Refresh exchanges a refresh token for a new access/refresh pair. Each refresh
+// token works once; presenting a used one revokes its whole family, since it
+// means the token was stolen or replayed.
+func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
+	req := struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}{}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rt, err := h.RefreshTokens.FindByHash(ctx, hashToken(req.RefreshToken))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if rt == nil || rt.RevokedAt != nil || time.Now().After(rt.ExpiresAt) {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid refresh token"})
+	}
+
+	fresh := rt.UsedAt == nil
+	if fresh {
+		if fresh, err = h.RefreshTokens.MarkUsed(ctx, rt.ID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if !fresh {
+		if err := h.sessions.end(ctx, rt.UserID, rt.FamilyID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Printf("refresh token reuse detected for user %s, family %s revoked", rt.UserID.Hex(), rt.FamilyID.Hex())
+		return c.Status(401).JSON(fiber.Map{"error": "refresh token reuse detected"})
+	}
+
+	user, err := h.UserRepo.FindByID(ctx, rt.UserID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid refresh token"})
+	}
+	if msg := loginBlocked(user); msg != "" {
+		return c.Status(403).JSON(fiber.Map{"error": msg})
+	}
+
+	s, err := h.sessions.records.FindByID(ctx, rt.FamilyID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	expires := time.Now().Add(h.RefreshTokenTTL)
+	switch {
+	case s == nil:
+		// the login predates session records; record it now
+		err = h.sessions.records.Create(ctx, &models.Session{
+			ID:        rt.FamilyID,
+			UserID:    rt.UserID,
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			IP:        c.IP(),
+			ExpiresAt: expires.UTC(),
+		})
+	case s.RevokedAt != nil:
+		return c.Status(401).JSON(fiber.Map{"error": "invalid refresh token"})
+	default:
+		err = h.sessions.records.Touch(ctx, s.ID, c.IP(), c.Get(fiber.HeaderUserAgent), expires)
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tokens, err := h.issueTokens(ctx, user, rt.FamilyID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(tokens)
+}
+
+// Logout ends the session the given refresh token belongs to. Its access
+// tokens stop working on the next request.
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	req := struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}{}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rt, err := h.RefreshTokens.FindByHash(ctx, hashToken(req.RefreshToken))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if rt != nil {
+		if err := h.sessions.end(ctx, rt.UserID, rt.FamilyID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	return c.SendStatus(204)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+// loginBlocked explains why user may not get tokens, or returns "".
+func loginBlocked(user *models.User) string {
+	switch {
+	case user.Disabled:
+		return "account disabled"
+	case user.PasswordResetRequired:
+		return "password reset required, check your email"
+	}
+	return ""
+}
+
+// issueTokens mints an access token and a refresh token belonging to familyID.
+func (h *AuthHandler) issueTokens(ctx context.Context, user *models.User, familyID primitive.ObjectID) (fiber.Map, error) {
+	role := user.Role
+	if role == "" {
+		role = models.RoleCustomer
+	}
+
+	now := time.Now()
+	tokenStr, err := h.Keys.Sign(jwt.MapClaims{
+		"user_id": user.ID.Hex(),
+		"role":    role,
+		"sid":     familyID.Hex(),
+		"typ":     "access",
+		"iat":     now.Unix(),
+		"exp":     now.Add(h.AccessTokenTTL).Unix(),
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, refreshHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.RefreshTokens.Create(ctx, &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: refreshHash,
+		ExpiresAt: now.Add(h.RefreshTokenTTL).UTC(),
+	}); err != nil {
+		return nil, err
+	}
 
-	tokenStr, _ := token.SignedString([]byte(h.JWTSecret))
-	return c.JSON(fiber.Map{"token": tokenStr})
+	return fiber.Map{
+		"token":         tokenStr,
+		"token_type":    "Bearer",
+		"expires_in":    int(h.AccessTokenTTL.Seconds()),
+		"refresh_token": refresh,
+	}, nil
 }
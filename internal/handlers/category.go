@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type CategoryHandler struct {
+	Categories *repo.CategoryRepo
+	Products   *repo.ProductRepo
+}
+
+func NewCategoryHandler(cr *repo.CategoryRepo, pr *repo.ProductRepo) *CategoryHandler {
+	return &CategoryHandler{
+		Categories: cr,
+		Products:   pr,
+	}
+}
+
+// List returns the whole tree flattened, each category directly followed by
+// its subtree; parent_id links it back up.
+func (h *CategoryHandler) List(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cats, err := h.Categories.List(ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(cats)
+}
+
+// Get returns a category by slug with its ancestors, root first, and its
+// direct children.
+func (h *CategoryHandler) Get(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cat, err := h.Categories.FindBySlug(ctx, strings.ToLower(c.Params("slug")))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if cat == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	ancestors, err := h.Categories.Ancestors(ctx, cat)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	children, err := h.Categories.Children(ctx, cat.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"category": cat, "ancestors": ancestors, "children": children})
+}
+
+func (h *CategoryHandler) Create(c *fiber.Ctx) error {
+	var req struct {
+		Name     string `json:"name" validate:"required,max=100"`
+		Slug     string `json:"slug" validate:"omitempty,max=100,slug"` // derived from name if empty
+		ParentID string `json:"parent_id" validate:"omitempty,mongodb"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+	cat := &models.Category{Name: req.Name, Slug: req.Slug}
+	if cat.Slug == "" {
+		if cat.Slug = slugify(req.Name); cat.Slug == "" {
+			return c.Status(422).JSON(fiber.Map{"error": "validation failed", "fields": []fiber.Map{
+				{"field": "slug", "rule": "required", "message": "is required when the name has no letters or digits"},
+			}})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var parent *models.Category
+	if req.ParentID != "" {
+		id, _ := primitive.ObjectIDFromHex(req.ParentID) // checked by the mongodb rule
+		p, err := h.Categories.FindByID(ctx, id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if p == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "parent category not found"})
+		}
+		parent = p
+	}
+
+	if err := h.Categories.Create(ctx, cat, parent); err != nil {
+		if err == repo.ErrSlugExists {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err == repo.ErrParentNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(cat)
+}
+
+// Update renames a category, changes its slug or moves it, with its whole
+// subtree, under another parent ("" for the top level).
+func (h *CategoryHandler) Update(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	// nil fields were absent from the body and are left unchanged
+	var req struct {
+		Name     *string `json:"name" validate:"omitempty,min=1,max=100"`
+		Slug     *string `json:"slug" validate:"omitempty,max=100,slug"`
+		ParentID *string `json:"parent_id"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cat, err := h.Categories.FindByID(ctx, oid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if cat == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+
+	// resolve the new parent before writing anything
+	move := false
+	var parent *models.Category
+	if req.ParentID != nil && *req.ParentID != "" {
+		pid, err := primitive.ObjectIDFromHex(*req.ParentID)
+		if err != nil {
+			return c.Status(422).JSON(fiber.Map{"error": "validation failed", "fields": []fiber.Map{
+				{"field": "parent_id", "rule": "mongodb", "message": "must be a valid id"},
+			}})
+		}
+		if parent, err = h.Categories.FindByID(ctx, pid); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if parent == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "parent category not found"})
+		}
+		if strings.HasPrefix(parent.Path, cat.Path) {
+			return c.Status(409).JSON(fiber.Map{"error": "a category can't be moved under itself"})
+		}
+		move = parent.ID != cat.ParentID
+	} else if req.ParentID != nil {
+		move = !cat.ParentID.IsZero()
+	}
+
+	update := bson.M{}
+	if req.Name != nil {
+		update["name"] = *req.Name
+	}
+	if req.Slug != nil {
+		update["slug"] = *req.Slug
+	}
+	if len(update) > 0 {
+		cat, err = h.Categories.Update(ctx, oid, update)
+		if err != nil {
+			if err == repo.ErrSlugExists {
+				return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if cat == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+	}
+	if move {
+		if err := h.Categories.Move(ctx, cat, parent); err != nil {
+			if err == repo.ErrParentNotFound {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	return c.JSON(cat)
+}
+
+// Delete removes a category with no subcategories. Its products are left
+// uncategorised rather than deleted.
+func (h *CategoryHandler) Delete(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cat, err := h.Categories.FindByID(ctx, oid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if cat == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	// the repo re-checks both in the delete itself, in case of a concurrent
+	// write since the lookup
+	if err := h.Categories.Delete(ctx, oid); err != nil {
+		if errors.Is(err, repo.ErrHasChildren) {
+			return c.Status(409).JSON(fiber.Map{"error": "move or delete the subcategories first"})
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.Products.ClearCategory(ctx, oid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.SendStatus(204)
+}
+
+// slugify turns a name into a slug: lowercase ASCII letters and digits, with
+// every other run of characters replaced by one hyphen.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	s := b.String()
+	if len(s) > 100 {
+		s = strings.TrimRight(s[:100], "-")
+	}
+	return s
+}
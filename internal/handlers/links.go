@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/mailer"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// linkMailer mails single-use links, e.g. for password resets. Issuing a link
+// invalidates any earlier one for the same user and purpose.
+type linkMailer struct {
+	tokens  *repo.OneTimeTokenRepo
+	mailer  mailer.Mailer
+	baseURL string
+}
+
+type link struct {
+	userID  primitive.ObjectID
+	to      string
+	email   string // stored on the token, e.g. the address being confirmed
+	purpose models.TokenPurpose
+	ttl     time.Duration
+	path    string // appended to baseURL, followed by ?token=
+	subject string
+	intro   string
+}
+
+func (m *linkMailer) send(ctx context.Context, l link) error {
+	if err := m.tokens.InvalidateForUser(ctx, l.userID, l.purpose); err != nil {
+		return err
+	}
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if err := m.tokens.Create(ctx, &models.OneTimeToken{
+		UserID:    l.userID,
+		Purpose:   l.purpose,
+		TokenHash: hash,
+		Email:     l.email,
+		ExpiresAt: time.Now().Add(l.ttl).UTC(),
+	}); err != nil {
+		return err
+	}
+	return m.mailer.Send(ctx, mailer.Message{
+		To:      l.to,
+		Subject: l.subject,
+		Body: fmt.Sprintf("%s\n\nOpen %s%s?token=%s within %s.\nIf it wasn't you, ignore this email.",
+			l.intro, m.baseURL, l.path, token, l.ttl),
+	})
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/config"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+)
+
+// LoginGuard throttles password logins per email and per client IP. Failed
+// attempts within a sliding window earn an exponentially growing delay before
+// the next attempt; reaching the limit locks the email or IP out entirely.
+type LoginGuard struct {
+	Attempts *repo.LoginAttemptRepo
+	Events   *repo.SecurityEventRepo
+
+	Window              time.Duration
+	MaxFailuresPerEmail int
+	MaxFailuresPerIP    int
+	Lockout             time.Duration
+	DelayBase           time.Duration
+	DelayMax            time.Duration
+}
+
+func NewLoginGuard(attempts *repo.LoginAttemptRepo, events *repo.SecurityEventRepo, cfg *config.Config) *LoginGuard {
+	return &LoginGuard{
+		Attempts:            attempts,
+		Events:              events,
+		Window:              cfg.LoginWindow,
+		MaxFailuresPerEmail: cfg.LoginMaxFailuresPerEmail,
+		MaxFailuresPerIP:    cfg.LoginMaxFailuresPerIP,
+		Lockout:             cfg.LoginLockout,
+		DelayBase:           cfg.LoginDelayBase,
+		DelayMax:            cfg.LoginDelayMax,
+	}
+}
+
+func emailKey(email string) string { return "email:" + strings.ToLower(strings.TrimSpace(email)) }
+func ipKey(ip string) string       { return "ip:" + ip }
+
+// Wait returns how long the caller has to wait before trying to log in again,
+// or zero if an attempt is allowed now.
+func (g *LoginGuard) Wait(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []string{emailKey(email), ipKey(ip)} {
+		until, err := g.Attempts.LockedUntil(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if d := until.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return wait, nil
+	}
+
+	// the IP limit is meant to catch credential stuffing, so only the
+	// per-email failures slow individual attempts down
+	n, last, err := g.Attempts.Failures(ctx, emailKey(email), now.Add(-g.Window))
+	if err != nil {
+		return 0, err
+	}
+	return g.retryAfter(now, last, n), nil
+}
+
+// retryAfter is how long after now the next attempt has to wait, given the
+// number of recent failures and when the last one happened.
+func (g *LoginGuard) retryAfter(now, last time.Time, failures int64) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	if d := last.Add(g.delay(failures)).Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// delay is DelayBase doubled for every failure after the first, capped at DelayMax.
+func (g *LoginGuard) delay(failures int64) time.Duration {
+	d := g.DelayBase
+	for i := int64(1); i < failures && d < g.DelayMax; i++ {
+		d *= 2
+	}
+	if d > g.DelayMax {
+		d = g.DelayMax
+	}
+	return d
+}
+
+// reachedLimit reports whether failures within the window lock a key out. A
+// max of zero or less turns the lockout off.
+func reachedLimit(failures int64, max int) bool {
+	return max > 0 && failures >= int64(max)
+}
+
+// Fail records a failed attempt and locks the email or IP once it reaches its limit.
+func (g *LoginGuard) Fail(ctx context.Context, email, ip string) error {
+	checks := []struct {
+		key string
+		max int
+	}{
+		{emailKey(email), g.MaxFailuresPerEmail},
+		{ipKey(ip), g.MaxFailuresPerIP},
+	}
+	now := time.Now()
+	for _, ch := range checks {
+		if err := g.Attempts.RecordFailure(ctx, ch.key, g.Window); err != nil {
+			return err
+		}
+		n, _, err := g.Attempts.Failures(ctx, ch.key, now.Add(-g.Window))
+		if err != nil {
+			return err
+		}
+		if !reachedLimit(n, ch.max) {
+			continue
+		}
+		if err := g.Attempts.Lock(ctx, ch.key, now.Add(g.Lockout)); err != nil {
+			return err
+		}
+		if err := g.Events.Record(ctx, &models.SecurityEvent{
+			Type:    models.EventLoginLockout,
+			Email:   email,
+			Key:     ch.key,
+			IP:      ip,
+			Details: fmt.Sprintf("locked for %s after %d failed logins in %s", g.Lockout, n, g.Window),
+		}); err != nil {
+			log.Printf("record lockout event: %v", err)
+		}
+	}
+	return nil
+}
+
+// Succeed forgets the email's failures after a successful login. IP failures
+// are kept, since one valid account doesn't vouch for the whole address.
+func (g *LoginGuard) Succeed(ctx context.Context, email string) error {
+	return g.Attempts.ClearFailures(ctx, emailKey(email))
+}
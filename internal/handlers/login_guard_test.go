@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginGuardDelay(t *testing.T) {
+	g := &LoginGuard{DelayBase: time.Second, DelayMax: 30 * time.Second}
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 16 * time.Second},
+		{6, 30 * time.Second}, // 32s capped
+		{100, 30 * time.Second},
+		{1 << 40, 30 * time.Second}, // stops doubling once capped
+	}
+	for _, tt := range tests {
+		if got := g.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	if got := (&LoginGuard{DelayBase: time.Minute, DelayMax: time.Second}).delay(1); got != time.Second {
+		t.Errorf("a base above the max is capped, got %s", got)
+	}
+	if got := (&LoginGuard{DelayMax: time.Second}).delay(10); got != 0 {
+		t.Errorf("a zero base never delays, got %s", got)
+	}
+}
+
+func TestLoginGuardRetryAfter(t *testing.T) {
+	g := &LoginGuard{DelayBase: time.Second, DelayMax: 30 * time.Second}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		failures int64
+		last     time.Time
+		want     time.Duration
+	}{
+		{"no failures", 0, now, 0},
+		{"first failure just now", 1, now, time.Second},
+		{"third failure a second ago", 3, now.Add(-time.Second), 3 * time.Second},
+		{"delay already served", 3, now.Add(-10 * time.Second), 0},
+		{"capped delay", 50, now.Add(-10 * time.Second), 20 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := g.retryAfter(now, tt.last, tt.failures); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReachedLimit(t *testing.T) {
+	tests := []struct {
+		failures int64
+		max      int
+		want     bool
+	}{
+		{4, 5, false},
+		{5, 5, true},
+		{6, 5, true},
+		{1, 1, true},
+		{100, 0, false}, // lockout disabled
+		{100, -1, false},
+	}
+	for _, tt := range tests {
+		if got := reachedLimit(tt.failures, tt.max); got != tt.want {
+			t.Errorf("reachedLimit(%d, %d) = %v, want %v", tt.failures, tt.max, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/oidc"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+)
+
+// how long a user has to finish logging in at the provider
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCHandler signs users in through OpenID Connect providers and then issues
+// our own tokens, exactly like a password login.
+type OIDCHandler struct {
+	Providers map[string]*oidc.Provider
+	States    *repo.OIDCStateRepo
+	auth      *AuthHandler
+}
+
+func NewOIDCHandler(providers []*oidc.Provider, states *repo.OIDCStateRepo, auth *AuthHandler) *OIDCHandler {
+	byName := make(map[string]*oidc.Provider, len(providers))
+	for _, p := range providers {
+		byName[p.Name()] = p
+	}
+	return &OIDCHandler{
+		Providers: byName,
+		States:    states,
+		auth:      auth,
+	}
+}
+
+// Login redirects the browser to the provider's consent page.
+func (h *OIDCHandler) Login(c *fiber.Ctx) error {
+	p, ok := h.Providers[c.Params("provider")]
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "unknown provider"})
+	}
+
+	state, err := oidc.RandomString(32)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	nonce, err := oidc.RandomString(32)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := h.States.Create(ctx, &models.OIDCState{
+		ID:           hashToken(state),
+		Provider:     p.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL).UTC(),
+	}); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// Callback finishes the login: it redeems the code, verifies the ID token and
+// finds, links or creates the matching user.
+func (h *OIDCHandler) Callback(c *fiber.Ctx) error {
+	p, ok := h.Providers[c.Params("provider")]
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "unknown provider"})
+	}
+	if e := c.Query("error"); e != "" {
+		return c.Status(401).JSON(fiber.Map{"error": "provider refused login: " + e})
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "code and state required"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	st, err := h.States.Consume(ctx, hashToken(state))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if st == nil || st.Provider != p.Name() {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired state"})
+	}
+
+	rawID, err := p.Exchange(ctx, code, st.CodeVerifier)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, err := p.VerifyIDToken(ctx, rawID, st.Nonce)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	user, err := h.findOrCreateUser(ctx, p.Name(), id)
+	if err == errUnverifiedAccount {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(403).JSON(fiber.Map{"error": "the provider did not return a verified email address"})
+	}
+
+	if msg := loginBlocked(user); msg != "" {
+		return c.Status(403).JSON(fiber.Map{"error": msg})
+	}
+	if user.TOTPEnabled {
+		return h.auth.mfaChallenge(c, user)
+	}
+	tokens, err := h.auth.startSession(ctx, c, user)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(tokens)
+}
+
+// errUnverifiedAccount means the provider's email belongs to a local account
+// that never proved it owns the address. Linking it would let whoever
+// registered it keep a password into the provider user's account.
+var errUnverifiedAccount = errors.New("an account with this email already exists; sign in with your password (or reset it) and verify your email address, then try again")
+
+// findOrCreateUser resolves an ID token to a user: by linked identity first,
+// then by verified email, creating a passwordless account if neither matches.
+// Only accounts whose email is verified are linked by email; others give
+// errUnverifiedAccount. It returns nil, nil when the provider didn't vouch for
+// the email.
+func (h *OIDCHandler) findOrCreateUser(ctx context.Context, provider string, id *oidc.IDToken) (*models.User, error) {
+	users := h.auth.UserRepo
+	if u, err := users.FindByIdentity(ctx, provider, id.Subject); err != nil || u != nil {
+		return u, err
+	}
+	if id.Email == "" || !id.EmailVerified {
+		return nil, nil
+	}
+
+	identity := models.Identity{Provider: provider, Subject: id.Subject, LinkedAt: time.Now().UTC()}
+	u, err := users.FindByEmail(ctx, id.Email)
+	if err != nil {
+		return nil, err
+	}
+	if u != nil {
+		if !u.EmailVerified {
+			return nil, errUnverifiedAccount
+		}
+		if err := users.LinkIdentity(ctx, u.ID, identity); err != nil {
+			return nil, err
+		}
+		log.Printf("linked %s identity to user %s", provider, u.ID.Hex())
+		return u, nil
+	}
+
+	name := id.Name
+	if name == "" {
+		name = id.Email
+	}
+	u = &models.User{
+		Name:          name,
+		Email:         id.Email,
+		Role:          models.RoleCustomer,
+		EmailVerified: true,
+		Identities:    []models.Identity{identity},
+	}
+	if err := users.Create(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -2,84 +2,292 @@ package handlers
 
 import (
 	"context"
-	"strconv"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/config"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/mailer"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/middleware"
 	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
 	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type OrderHandler struct {
-	Products *repo.ProductRepo
-	Orders   *repo.OrderRepo
+	Products  *repo.ProductRepo
+	Orders    *repo.OrderRepo
+	Users     *repo.UserRepo
+	Addresses *repo.AddressRepo
+
+	RequireVerifiedEmail bool
+	mailer               mailer.Mailer
+	baseURL              string
 }
 
-func NewOrderHandler(pr *repo.ProductRepo, or *repo.OrderRepo) *OrderHandler {
+func NewOrderHandler(pr *repo.ProductRepo, or *repo.OrderRepo, ur *repo.UserRepo, ar *repo.AddressRepo, m mailer.Mailer, cfg *config.Config) *OrderHandler {
 	return &OrderHandler{
-		Products: pr,
-		Orders:   or,
+		Products:             pr,
+		Orders:               or,
+		Users:                ur,
+		Addresses:            ar,
+		RequireVerifiedEmail: cfg.RequireVerifiedEmail,
+		mailer:               m,
+		baseURL:              strings.TrimRight(cfg.AppBaseURL, "/"),
 	}
 }
 
+// orderLine is one requested line of a new order.
+type orderLine struct {
+	ProductID string `json:"product_id" validate:"required,mongodb"`
+	Quantity  int    `json:"quantity" validate:"required,min=1,max=1000"`
+}
+
 func (h *OrderHandler) Create(c *fiber.Ctx) error {
 	var req struct {
-		UserID string `json:"user_id"`
-		Items  []struct {
-			ProductID string `json:"product_id"`
-			Quantity  int    `json:"quantity"`
-		} `json:"items"`
+		Items []orderLine `json:"items" validate:"required,min=1,max=100,dive"`
+
+		// a saved address or an inline one; the default from the address
+		// book is used when both are absent
+		ShippingAddressID string        `json:"shipping_address_id" validate:"omitempty,mongodb"`
+		ShippingAddress   *addressInput `json:"shipping_address"`
+		BillingAddressID  string        `json:"billing_address_id" validate:"omitempty,mongodb"`
+		BillingAddress    *addressInput `json:"billing_address"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
 	}
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid body"})
+	if (req.ShippingAddressID != "" && req.ShippingAddress != nil) || (req.BillingAddressID != "" && req.BillingAddress != nil) {
+		return c.Status(400).JSON(fiber.Map{"error": "send either an address id or an inline address, not both"})
 	}
 
-	userOID, err := primitive.ObjectIDFromHex(req.UserID)
+	userOID := middleware.GetClaims(c).UserID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	if h.RequireVerifiedEmail {
+		u, err := h.Users.FindByID(ctx, userOID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if u == nil || !u.EmailVerified {
+			return c.Status(403).JSON(fiber.Map{"error": "verify your email address before placing orders"})
+		}
+	}
+
+	shipping, err := h.orderAddress(ctx, userOID, req.ShippingAddressID, req.ShippingAddress, repo.DefaultShipping)
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid user_id"})
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if shipping == nil {
+		if req.ShippingAddressID != "" {
+			return c.Status(404).JSON(fiber.Map{"error": "shipping address not found"})
+		}
+		return c.Status(422).JSON(fiber.Map{"error": "validation failed", "fields": []fiber.Map{
+			{"field": "shipping_address", "rule": "required", "message": "is required when you have no default shipping address"},
+		}})
+	}
+	billing, err := h.orderAddress(ctx, userOID, req.BillingAddressID, req.BillingAddress, repo.DefaultBilling)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if billing == nil {
+		if req.BillingAddressID != "" {
+			return c.Status(404).JSON(fiber.Map{"error": "billing address not found"})
+		}
+		billing = shipping
+	}
+
+	order := &models.Order{
+		UserID:          userOID,
+		ShippingAddress: shipping,
+		BillingAddress:  billing,
+	}
+	if ok, err := h.place(ctx, c, order, req.Items); !ok {
+		return err
+	}
+	return c.Status(201).JSON(order)
+}
+
+// CreateGuest places an order without an account. The response carries a
+// lookup token, also mailed to the buyer, which is the only way to read the
+// order back until the email address is verified on an account.
+func (h *OrderHandler) CreateGuest(c *fiber.Ctx) error {
+	// a guest's email is never verified, so the policy rules guest orders out
+	if h.RequireVerifiedEmail {
+		return c.Status(403).JSON(fiber.Map{"error": "sign in with a verified email address to place orders"})
+	}
+	var req struct {
+		Email           string        `json:"email" validate:"required,email,max=254"`
+		Items           []orderLine   `json:"items" validate:"required,min=1,max=100,dive"`
+		ShippingAddress *addressInput `json:"shipping_address" validate:"required"`
+		BillingAddress  *addressInput `json:"billing_address"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	shipping := req.ShippingAddress.toModel()
+	billing := shipping
+	if req.BillingAddress != nil {
+		billing = req.BillingAddress.toModel()
+	}
+	order := &models.Order{
+		GuestEmail:      guestEmail(req.Email),
+		LookupTokenHash: hash,
+		ShippingAddress: &shipping,
+		BillingAddress:  &billing,
 	}
 
-	var items []models.OrderItem
-	var total float64
 	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
 	defer cancel()
+	if ok, err := h.place(ctx, c, order, req.Items); !ok {
+		return err
+	}
+
+	// the token is in the response too, so a lost email isn't fatal
+	if err := h.mailer.Send(ctx, mailer.Message{
+		To:      req.Email,
+		Subject: "Your order " + order.ID.Hex(),
+		Body: fmt.Sprintf("Thanks for your order.\n\nYou can check on it at %s/api/orders/guest/%s?token=%s\nVerify this address on an account to see it with your other orders.",
+			h.baseURL, order.ID.Hex(), token),
+	}); err != nil {
+		log.Printf("guest order email for %s: %v", order.ID.Hex(), err)
+	}
+	return c.Status(201).JSON(fiber.Map{"order": order, "lookup_token": token})
+}
 
-	for _, it := range req.Items {
+// GetGuest serves a guest order to whoever holds its lookup token. Wrong
+// tokens get the same 404 as unknown orders.
+func (h *OrderHandler) GetGuest(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "token required"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	o, err := h.Orders.GetById(ctx, oid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if o == nil || o.LookupTokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(o.LookupTokenHash)) != 1 {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(o)
+}
+
+// guestEmail normalises an address the way guest orders store it.
+func guestEmail(email string) string { return strings.ToLower(strings.TrimSpace(email)) }
+
+// place prices lines into order, reserves their stock and stores the order.
+// On false the error response has already been written.
+func (h *OrderHandler) place(ctx context.Context, c *fiber.Ctx, order *models.Order, lines []orderLine) (bool, error) {
+	var items []models.OrderItem
+	var inStock []int // parallel to items
+	var total float64
+	lineOf := map[primitive.ObjectID]int{}
+
+	for _, it := range lines {
 		pid, err := primitive.ObjectIDFromHex(it.ProductID)
 		if err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": "invalid product_id"})
+			return false, c.Status(400).JSON(fiber.Map{"error": "invalid product_id"})
+		}
+		// repeated products are merged into one line so stock is checked once
+		if i, seen := lineOf[pid]; seen {
+			items[i].Quantity += it.Quantity
+			total += items[i].Price * float64(it.Quantity)
+			continue
 		}
 		p, err := h.Products.GetById(ctx, pid)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return false, c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
 		if p == nil {
-			return c.Status(404).JSON(fiber.Map{"error": "product not found"})
-		}
-		if it.Quantity < 1 {
-			return c.Status(400).JSON(fiber.Map{"error": "quantity must be >=1"})
+			return false, c.Status(404).JSON(fiber.Map{"error": "product not found"})
 		}
 
+		lineOf[pid] = len(items)
 		items = append(items, models.OrderItem{
 			ProductID: pid,
 			Quantity:  it.Quantity,
 			Price:     p.Price,
 		})
 		total += p.Price * float64(it.Quantity)
+		inStock = append(inStock, p.Stock)
 	}
 
-	order := &models.Order{
-		UserID: userOID,
-		Items:  items,
-		Total:  total,
-		Status: "pending",
+	// report every short line up front rather than failing on the first one
+	var short []models.StockShortage
+	for i, it := range items {
+		if it.Quantity > inStock[i] {
+			short = append(short, models.StockShortage{ProductID: it.ProductID, Requested: it.Quantity, Available: inStock[i]})
+		}
+	}
+	if len(short) > 0 {
+		return false, c.Status(409).JSON(fiber.Map{"error": "insufficient stock", "items": short})
+	}
+
+	short, err := h.Products.ReserveStock(ctx, items)
+	if err != nil {
+		return false, c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(short) > 0 {
+		return false, c.Status(409).JSON(fiber.Map{"error": "insufficient stock", "items": short})
 	}
 
+	order.Items = items
+	order.Total = total
+	order.Status = models.OrderPending
+	placed := models.StatusChange{To: models.OrderPending, Note: "placed by guest"}
+	if claims := middleware.GetClaims(c); claims != nil {
+		placed.ActorID, placed.APIKeyID, placed.Note = claims.UserID, claims.APIKeyID, "placed"
+	}
+	order.History = []models.StatusChange{placed}
 	if err := h.Orders.Create(ctx, order); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		if rbErr := h.Products.ReleaseStock(ctx, items); rbErr != nil {
+			log.Printf("release stock for failed order: %v", rbErr)
+		}
+		return false, c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	return c.Status(201).JSON(order)
+	return true, nil
+}
+
+// orderAddress resolves the address to copy into an order: the saved address
+// id, else the inline address, else the user's default for flag. It returns
+// nil if the id is unknown or nothing applies.
+func (h *OrderHandler) orderAddress(ctx context.Context, uid primitive.ObjectID, idHex string, inline *addressInput, flag string) (*models.Address, error) {
+	if inline != nil {
+		a := inline.toModel()
+		return &a, nil
+	}
+	var saved *models.SavedAddress
+	var err error
+	if idHex != "" {
+		id, _ := primitive.ObjectIDFromHex(idHex) // checked by the mongodb rule
+		saved, err = h.Addresses.Get(ctx, uid, id)
+	} else {
+		saved, err = h.Addresses.Default(ctx, uid, flag)
+	}
+	if err != nil || saved == nil {
+		return nil, err
+	}
+	return &saved.Address, nil
 }
 
 func (h *OrderHandler) Get(c *fiber.Ctx) error {
@@ -95,32 +303,65 @@ func (h *OrderHandler) Get(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	if o == nil {
+	if o == nil || !canAccessOrder(c, o) {
 		return c.Status(404).JSON(fiber.Map{"error": "not found"})
 	}
 	return c.JSON(o)
 }
 
+// ListByUser lets admins and orders:read API keys list any user's orders via
+// ?user_id=; everyone else only ever sees their own.
 func (h *OrderHandler) ListByUser(c *fiber.Ctx) error {
+	claims := middleware.GetClaims(c)
 	userHex := c.Query("user_id", "")
+	if claims.IsAPIKey() && userHex == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "user_id is required"})
+	}
 	if userHex == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "user_id required"})
+		return h.listForUser(c, claims.UserID)
 	}
 	uid, err := primitive.ObjectIDFromHex(userHex)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid user_id"})
 	}
+	if uid != claims.UserID && !claims.IsAdmin() && !claims.HasScope(models.ScopeOrdersRead) {
+		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+	return h.listForUser(c, uid)
+}
+
+// ListMine serves /api/me/orders.
+func (h *OrderHandler) ListMine(c *fiber.Ctx) error {
+	return h.listForUser(c, middleware.GetClaims(c).UserID)
+}
+
+// ListForUser serves /api/admin/users/:id/orders.
+func (h *OrderHandler) ListForUser(c *fiber.Ctx) error {
+	uid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	return h.listForUser(c, uid)
+}
 
-	page, _ := strconv.Atoi(c.Query("page", "1"))
-	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+func (h *OrderHandler) listForUser(c *fiber.Ctx, uid primitive.ObjectID) error {
+	pq := readPageQuery(c)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
-	items, err := h.Orders.ListByUser(ctx, uid, page, limit)
+	page, err := h.Orders.ListByUser(ctx, uid, pq.cursor, pq.limit)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		return pageError(c, err)
 	}
-	return c.JSON(items)
+	var total *int64
+	if pq.includeTotal {
+		n, err := h.Orders.CountByUser(ctx, uid)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		total = &n
+	}
+	return sendPage(c, page, total)
 }
 
 func (h *OrderHandler) UpdateStatus(c *fiber.Ctx) error {
@@ -131,24 +372,84 @@ func (h *OrderHandler) UpdateStatus(c *fiber.Ctx) error {
 	}
 
 	var req struct {
-		Status string `json:"status"`
+		Status models.OrderStatus `json:"status" validate:"required,valid"`
+		Note   string             `json:"note" validate:"max=500"`
 	}
-	if err := c.BodyParser(&req); err != nil || req.Status == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "status required"})
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
 	}
 
+	claims := middleware.GetClaims(c)
+	change := models.StatusChange{Note: req.Note, ActorID: claims.UserID, APIKeyID: claims.APIKeyID}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	o, err := h.Orders.UpdateStatus(ctx, oid, req.Status)
+	cur, err := h.Orders.GetById(ctx, oid)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	if o == nil {
+	if cur == nil {
 		return c.Status(404).JSON(fiber.Map{"error": "not found"})
 	}
+	if !cur.Status.CanTransitionTo(req.Status) {
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "illegal status transition",
+			"from":    cur.Status,
+			"to":      req.Status,
+			"allowed": cur.Status.NextStatuses(),
+		})
+	}
+
+	return h.transition(ctx, c, cur, req.Status, change)
+}
+
+// transition moves cur to status, recording change, and restocks its items
+// when the move calls for it. The caller has checked the move is legal.
+func (h *OrderHandler) transition(ctx context.Context, c *fiber.Ctx, cur *models.Order, status models.OrderStatus, change models.StatusChange) error {
+	change.From, change.To = cur.Status, status
+	o, err := h.Orders.UpdateStatus(ctx, cur.ID, change)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if o == nil {
+		return c.Status(409).JSON(fiber.Map{"error": "order was modified concurrently, retry"})
+	}
+
+	if models.RestocksOn(cur.Status, o.Status) {
+		if err := h.Products.ReleaseStock(ctx, o.Items); err != nil {
+			log.Printf("restock order %s: %v", o.ID.Hex(), err)
+		}
+	}
 	return c.JSON(o)
 }
 
+// History returns the order's status timeline, oldest first.
+func (h *OrderHandler) History(c *fiber.Ctx) error {
+	idHex := c.Params("id")
+	oid, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	o, err := h.Orders.GetById(ctx, oid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if o == nil || !canAccessOrder(c, o) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	history := o.History
+	if history == nil {
+		history = []models.StatusChange{}
+	}
+	return c.JSON(fiber.Map{"order_id": o.ID, "status": o.Status, "history": history})
+}
+
+// Delete lets a customer cancel their own order while it is still pending;
+// the order and its history are kept. Admins remove the order outright, and
+// any stock it still holds is put back.
 func (h *OrderHandler) Delete(c *fiber.Ctx) error {
 	idHex := c.Params("id")
 	oid, err := primitive.ObjectIDFromHex(idHex)
@@ -158,11 +459,44 @@ func (h *OrderHandler) Delete(c *fiber.Ctx) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+
+	o, err := h.Orders.GetById(ctx, oid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if o == nil || !canAccessOrder(c, o) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+
+	claims := middleware.GetClaims(c)
+	if !claims.IsAdmin() {
+		if o.Status != models.OrderPending {
+			return c.Status(409).JSON(fiber.Map{"error": "only pending orders can be cancelled", "status": o.Status})
+		}
+		change := models.StatusChange{Note: "cancelled by customer", ActorID: claims.UserID}
+		return h.transition(ctx, c, o, models.OrderCancelled, change)
+	}
+
 	if err := h.Orders.Delete(ctx, oid); err != nil {
-		if err.Error() == "mongo: no documents in result" {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return c.Status(404).JSON(fiber.Map{"error": "not found"})
 		}
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	// cancelling would have restocked; delivered or refunded goods are gone
+	if o.Status.CanTransitionTo(models.OrderCancelled) {
+		if err := h.Products.ReleaseStock(ctx, o.Items); err != nil {
+			log.Printf("restock deleted order %s: %v", o.ID.Hex(), err)
+		}
+	}
 	return c.SendStatus(204)
 }
+
+// canAccessOrder reports whether the caller owns o, is an admin or holds an
+// orders:read API key. Orders belonging to someone else are reported as 404
+// so their IDs don't leak. Unclaimed guest orders are owned by nobody.
+func canAccessOrder(c *fiber.Ctx, o *models.Order) bool {
+	claims := middleware.GetClaims(c)
+	return claims.IsAdmin() || claims.HasScope(models.ScopeOrdersRead) ||
+		(!o.UserID.IsZero() && o.UserID == claims.UserID)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+)
+
+// pageQuery reads the keyset pagination parameters: ?cursor=...&limit=20,
+// plus include_total=true to also count every match.
+type pageQuery struct {
+	cursor       string
+	limit        int
+	includeTotal bool
+}
+
+func readPageQuery(c *fiber.Ctx) pageQuery {
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	total, _ := strconv.ParseBool(c.Query("include_total"))
+	return pageQuery{cursor: c.Query("cursor"), limit: limit, includeTotal: total}
+}
+
+type pageEnvelope[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// sendPage writes p in the list envelope, with RFC 8288 Link headers for the
+// first and next pages. total is nil unless it was asked for.
+func sendPage[T any](c *fiber.Ctx, p *repo.Page[T], total *int64) error {
+	links := []string{pageURL(c, ""), "first"}
+	if p.HasMore {
+		links = append(links, pageURL(c, p.NextCursor), "next")
+	}
+	c.Links(links...)
+	return c.JSON(pageEnvelope[T]{Items: p.Items, NextCursor: p.NextCursor, HasMore: p.HasMore, Total: total})
+}
+
+// pageURL is the current request's URL with its cursor replaced.
+func pageURL(c *fiber.Ctx, cursor string) string {
+	q, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	q.Del("cursor")
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	if len(q) == 0 {
+		return c.BaseURL() + c.Path()
+	}
+	return c.BaseURL() + c.Path() + "?" + q.Encode()
+}
+
+// pageError answers for a failed list query: 400 for a bad cursor, else 500.
+func pageError(c *fiber.Ctx, err error) error {
+	if err == repo.ErrInvalidCursor {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/config"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/mailer"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type PasswordHandler struct {
+	Users         *repo.UserRepo
+	Tokens        *repo.OneTimeTokenRepo
+	RefreshTokens *repo.RefreshTokenRepo
+	ResetTTL      time.Duration
+	links         *linkMailer
+	sessions      *sessionStore
+}
+
+func NewPasswordHandler(users *repo.UserRepo, tokens *repo.OneTimeTokenRepo, refresh *repo.RefreshTokenRepo, sessions *repo.SessionRepo, m mailer.Mailer, cfg *config.Config) *PasswordHandler {
+	return &PasswordHandler{
+		Users:         users,
+		Tokens:        tokens,
+		RefreshTokens: refresh,
+		ResetTTL:      cfg.PasswordResetTTL,
+		links:         &linkMailer{tokens: tokens, mailer: m, baseURL: strings.TrimRight(cfg.AppBaseURL, "/")},
+		sessions:      &sessionStore{records: sessions, refresh: refresh},
+	}
+}
+
+// Forgot mails a reset link if the email belongs to an account. It answers the
+// same way either way so it can't be used to discover registered emails.
+func (h *PasswordHandler) Forgot(c *fiber.Ctx) error {
+	var req struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	accepted := fiber.Map{"message": "if the account exists, a reset link has been sent"}
+	user, err := h.Users.FindByEmail(ctx, req.Email)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(202).JSON(accepted)
+	}
+
+	if err := h.sendReset(ctx, user); err != nil {
+		log.Printf("password reset for %s: %v", user.ID.Hex(), err)
+	}
+	return c.Status(202).JSON(accepted)
+}
+
+// Reset sets a new password using a token from Forgot and signs the user out
+// everywhere.
+func (h *PasswordHandler) Reset(c *fiber.Ctx) error {
+	var req struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,min=8,max=72"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t, err := h.Tokens.Consume(ctx, hashToken(req.Token), models.PurposePasswordReset)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if t == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.Users.UpdatePassword(ctx, t.UserID, string(hash)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.sessions.revokeAll(ctx, t.UserID, primitive.NilObjectID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "password updated"})
+}
+
+// sendReset replaces any outstanding reset token for user with a new one and
+// mails it.
+func (h *PasswordHandler) sendReset(ctx context.Context, user *models.User) error {
+	uid := user.ID
+	return h.links.send(ctx, link{
+		userID:  uid,
+		to:      user.Email,
+		purpose: models.PurposePasswordReset,
+		ttl:     h.ResetTTL,
+		path:    "/reset-password",
+		subject: "Reset your password",
+		intro:   "Someone asked to reset the password for this account.",
+	})
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/middleware"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PrivacyHandler serves data subject requests: exporting a user's data and
+// erasing it.
+type PrivacyHandler struct {
+	Users          *repo.UserRepo
+	Orders         *repo.OrderRepo
+	Addresses      *repo.AddressRepo
+	RefreshTokens  *repo.RefreshTokenRepo
+	Sessions       *repo.SessionRepo
+	OneTimeTokens  *repo.OneTimeTokenRepo
+	LoginAttempts  *repo.LoginAttemptRepo
+	SecurityEvents *repo.SecurityEventRepo
+}
+
+func NewPrivacyHandler(users *repo.UserRepo, orders *repo.OrderRepo, addresses *repo.AddressRepo, refresh *repo.RefreshTokenRepo, sessions *repo.SessionRepo, oneTime *repo.OneTimeTokenRepo, attempts *repo.LoginAttemptRepo, events *repo.SecurityEventRepo) *PrivacyHandler {
+	return &PrivacyHandler{
+		Users:          users,
+		Orders:         orders,
+		Addresses:      addresses,
+		RefreshTokens:  refresh,
+		Sessions:       sessions,
+		OneTimeTokens:  oneTime,
+		LoginAttempts:  attempts,
+		SecurityEvents: events,
+	}
+}
+
+// orderExport includes the status history the regular order JSON leaves out.
+type orderExport struct {
+	models.Order
+	History []models.StatusChange `json:"history"`
+}
+
+// Export returns everything we hold about the logged-in user as a JSON file.
+func (h *PrivacyHandler) Export(c *fiber.Ctx) error {
+	uid := middleware.GetClaims(c).UserID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	user, err := h.Users.FindByID(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err := h.claimGuestOrders(ctx, user); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	orders, err := h.Orders.AllByUser(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	addresses, err := h.Addresses.ListByUser(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	exported := make([]orderExport, len(orders))
+	for i, o := range orders {
+		history := o.History
+		if history == nil {
+			history = []models.StatusChange{}
+		}
+		exported[i] = orderExport{Order: o, History: history}
+	}
+
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="export-`+uid.Hex()+`.json"`)
+	return c.JSON(fiber.Map{
+		"exported_at": time.Now().UTC(),
+		"user":        user,
+		"addresses":   addresses,
+		"orders":      exported,
+	})
+}
+
+// EraseMe erases the logged-in user's account. Accounts with a password must
+// confirm it.
+func (h *PrivacyHandler) EraseMe(c *fiber.Ctx) error {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+	uid := middleware.GetClaims(c).UserID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := h.Users.FindByID(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if user.PasswordHash != "" && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid credentials"})
+	}
+	if err := h.erase(ctx, user, uid, uid); err != nil {
+		return eraseError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "account erased"})
+}
+
+func eraseError(c *fiber.Ctx, err error) error {
+	if err == repo.ErrLastAdmin {
+		return c.Status(409).JSON(fiber.Map{"error": "the last active admin can't be erased; promote another admin first"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// EraseUser is the admin variant, for requests received out of band.
+func (h *PrivacyHandler) EraseUser(c *fiber.Ctx) error {
+	uid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	actor := middleware.GetClaims(c).UserID
+	if uid == actor {
+		return c.Status(409).JSON(fiber.Map{"error": "use /api/me/erase to erase your own account"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := h.Users.FindByID(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err := h.erase(ctx, user, uid, actor); err != nil {
+		return eraseError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "account erased"})
+}
+
+// erase drops the user's credentials, sessions, address book and login
+// traces and then anonymises the account. Orders keep their items and amounts
+// for accounting, but their address copies are cut down to the country.
+//
+// Every step is safe to repeat and the account is only marked erased by the
+// last one, so a failed erasure is finished by simply retrying it. The account
+// is disabled first, which refuses the erasure of the last active admin before
+// anything is lost.
+func (h *PrivacyHandler) erase(ctx context.Context, user *models.User, uid, actor primitive.ObjectID) error {
+	if user.ErasedAt != nil {
+		return nil
+	}
+	if err := h.Users.SetDisabled(ctx, uid, true); err != nil {
+		return err
+	}
+	if err := h.RefreshTokens.RevokeAllForUser(ctx, uid); err != nil {
+		return err
+	}
+	// session records hold IPs and user agents, so they go entirely
+	if err := h.Sessions.DeleteForUser(ctx, uid); err != nil {
+		return err
+	}
+	if err := h.Addresses.DeleteForUser(ctx, uid); err != nil {
+		return err
+	}
+	// guest orders placed with the address are theirs too
+	if err := h.claimGuestOrders(ctx, user); err != nil {
+		return err
+	}
+	if err := h.Orders.ScrubAddresses(ctx, uid); err != nil {
+		return err
+	}
+	if err := h.OneTimeTokens.DeleteForUser(ctx, uid); err != nil {
+		return err
+	}
+	if err := h.LoginAttempts.Forget(ctx, emailKey(user.Email)); err != nil {
+		return err
+	}
+	if err := h.SecurityEvents.ScrubEmail(ctx, user.Email); err != nil {
+		return err
+	}
+	if err := h.SecurityEvents.Record(ctx, &models.SecurityEvent{
+		Type:    models.EventAccountErased,
+		Details: "user " + uid.Hex() + " erased by " + actor.Hex(),
+	}); err != nil {
+		return err
+	}
+	return h.Users.Anonymize(ctx, uid)
+}
+
+// claimGuestOrders attaches the guest orders placed with the user's email
+// before their data is exported or erased. Unverified addresses claim
+// nothing, as the orders might be someone else's.
+func (h *PrivacyHandler) claimGuestOrders(ctx context.Context, user *models.User) error {
+	if !user.EmailVerified || user.ErasedAt != nil {
+		return nil
+	}
+	_, err := h.Orders.ClaimGuestOrders(ctx, guestEmail(user.Email), user.ID)
+	return err
+}
@@ -2,7 +2,7 @@ package handlers
 
 import (
 	"context"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,24 +13,28 @@ import (
 )
 
 type ProductHandler struct {
-	Products *repo.ProductRepo
+	Products   *repo.ProductRepo
+	Categories *repo.CategoryRepo
 }
 
-func NewProductHandler(pr *repo.ProductRepo) *ProductHandler {
+func NewProductHandler(pr *repo.ProductRepo, cr *repo.CategoryRepo) *ProductHandler {
 	return &ProductHandler{
-		Products: pr,
+		Products:   pr,
+		Categories: cr,
 	}
 }
 
 func (h *ProductHandler) Create(c *fiber.Ctx) error {
 	var req struct {
-		Name, Description string
-		Price             float64
-		Stock             int
+		Name        string  `json:"name" validate:"required,max=200"`
+		Description string  `json:"description" validate:"max=5000"`
+		Price       float64 `json:"price" validate:"positive"`
+		Stock       int     `json:"stock" validate:"min=0"`
+		CategoryID  string  `json:"category_id" validate:"omitempty,mongodb"`
 	}
 
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid body"})
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
 	}
 
 	p := &models.Product{
@@ -42,6 +46,14 @@ func (h *ProductHandler) Create(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if req.CategoryID != "" {
+		id, ok, err := h.category(ctx, c, req.CategoryID)
+		if !ok {
+			return err
+		}
+		p.CategoryID = id
+	}
+
 	if err := h.Products.Create(ctx, p); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -49,18 +61,106 @@ func (h *ProductHandler) Create(c *fiber.Ctx) error {
 }
 
 func (h *ProductHandler) List(c *fiber.Ctx) error {
-	page, _ := strconv.Atoi(c.Query("page", "1"))
-	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	return h.list(c, c.Query("category"))
+}
+
+// ListInCategory serves /api/categories/:slug/products: the products in the
+// category or anywhere below it, with the same filters as List.
+func (h *ProductHandler) ListInCategory(c *fiber.Ctx) error {
+	return h.list(c, c.Params("slug"))
+}
+
+func (h *ProductHandler) list(c *fiber.Ctx, categorySlug string) error {
+	pq := readPageQuery(c)
+
+	var req struct {
+		MinPrice     *float64 `query:"min_price" json:"min_price" validate:"omitempty,min=0"`
+		MaxPrice     *float64 `query:"max_price" json:"max_price" validate:"omitempty,min=0"`
+		InStock      bool     `query:"in_stock" json:"in_stock"`
+		CreatedAfter string   `query:"created_after" json:"created_after"` // RFC 3339 or YYYY-MM-DD
+		Sort         string   `query:"sort" json:"sort" validate:"omitempty,oneof=price -price name -name created_at -created_at"`
+	}
+	if ok, err := bindQuery(c, &req); !ok {
+		return err
+	}
+	if req.MinPrice != nil && req.MaxPrice != nil && *req.MaxPrice < *req.MinPrice {
+		return c.Status(422).JSON(fiber.Map{"error": "validation failed", "fields": []fiber.Map{
+			{"field": "max_price", "rule": "gtefield", "param": "min_price", "message": "must be at least min_price"},
+		}})
+	}
+	filter := repo.ProductFilter{
+		MinPrice: req.MinPrice,
+		MaxPrice: req.MaxPrice,
+		InStock:  req.InStock,
+		Sort:     req.Sort,
+	}
+	if req.CreatedAfter != "" {
+		t, err := parseTimeParam(req.CreatedAfter)
+		if err != nil {
+			return c.Status(422).JSON(fiber.Map{"error": "validation failed", "fields": []fiber.Map{
+				{"field": "created_after", "rule": "datetime", "message": "must be an RFC 3339 timestamp or a YYYY-MM-DD date"},
+			}})
+		}
+		filter.CreatedAfter = t
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	items, err := h.Products.List(ctx, page, limit)
+	if categorySlug != "" {
+		cat, err := h.Categories.FindBySlug(ctx, strings.ToLower(categorySlug))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if cat == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "category not found"})
+		}
+		if filter.CategoryIDs, err = h.Categories.SubtreeIDs(ctx, cat); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	page, err := h.Products.List(ctx, filter, pq.cursor, pq.limit)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		return pageError(c, err)
 	}
-	return c.JSON(items)
+	var total *int64
+	if pq.includeTotal {
+		n, err := h.Products.Count(ctx, filter)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		total = &n
+	}
+	return sendPage(c, page, total)
+}
 
+// category checks that the category a product is being put in exists. When
+// ok is false the error response has already been written.
+func (h *ProductHandler) category(ctx context.Context, c *fiber.Ctx, idHex string) (id primitive.ObjectID, ok bool, err error) {
+	id, err = primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return id, false, c.Status(422).JSON(fiber.Map{"error": "validation failed", "fields": []fiber.Map{
+			{"field": "category_id", "rule": "mongodb", "message": "must be a valid id"},
+		}})
+	}
+	cat, err := h.Categories.FindByID(ctx, id)
+	if err != nil {
+		return id, false, c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if cat == nil {
+		return id, false, c.Status(404).JSON(fiber.Map{"error": "category not found"})
+	}
+	return id, true, nil
+}
+
+// parseTimeParam accepts a full RFC 3339 timestamp or a bare date, taken as
+// midnight UTC.
+func parseTimeParam(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", s)
 }
 
 func (h *ProductHandler) Get(c *fiber.Ctx) error {
@@ -89,27 +189,45 @@ func (h *ProductHandler) Update(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
 	}
-	var req map[string]interface{}
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid body"})
+	// nil fields were absent from the body and are left unchanged
+	var req struct {
+		Name        *string  `json:"name" validate:"omitempty,min=1,max=200"`
+		Description *string  `json:"description" validate:"omitempty,max=5000"`
+		Price       *float64 `json:"price" validate:"omitempty,positive"`
+		Stock       *int     `json:"stock" validate:"omitempty,min=0"`
+		CategoryID  *string  `json:"category_id"` // "" removes the product from its category
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
 	}
 	update := bson.M{}
-	if v, ok := req["name"].(string); ok {
-		update["name"] = v
+	if req.Name != nil {
+		update["name"] = *req.Name
 	}
-	if v, ok := req["description"].(string); ok {
-		update["description"] = v
+	if req.Description != nil {
+		update["description"] = *req.Description
 	}
-	if v, ok := req["price"].(float64); ok {
-		update["price"] = v
+	if req.Price != nil {
+		update["price"] = *req.Price
+	}
+	if req.Stock != nil {
+		update["stock"] = *req.Stock
 	}
-	if v, ok := req["stock"].(float64); ok {
-		update["stock"] = int(v)
-	} // JSON numbers -> float64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if req.CategoryID != nil {
+		update["category_id"] = nil
+		if *req.CategoryID != "" {
+			id, ok, err := h.category(ctx, c, *req.CategoryID)
+			if !ok {
+				return err
+			}
+			update["category_id"] = id
+		}
+	}
+
 	p, err := h.Products.Update(ctx, oid, update)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+)
+
+// snippetWidth is roughly how many bytes of description are kept either
+// side of the first match.
+const snippetWidth = 80
+
+type searchHit struct {
+	models.ProductMatch
+	Highlights map[string]string `json:"highlights"` // HTML-escaped, matches wrapped in <mark>
+}
+
+// Search serves /api/products/search?q=..., ranked by text relevance.
+func (h *ProductHandler) Search(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "q is required"})
+	}
+	if len(q) > 200 {
+		return c.Status(400).JSON(fiber.Map{"error": "q is too long"})
+	}
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if page > repo.MaxSearchPage {
+		return c.Status(400).JSON(fiber.Map{"error": "page is too large; refine the search instead"})
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	matches, total, err := h.Products.Search(ctx, q, page, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	terms := searchTerms(q)
+	items := make([]searchHit, len(matches))
+	for i, m := range matches {
+		hl := map[string]string{}
+		if s := snippet(m.Name, terms, len(m.Name)); s != "" {
+			hl["name"] = s
+		}
+		if s := snippet(m.Description, terms, snippetWidth); s != "" {
+			hl["description"] = s
+		}
+		items[i] = searchHit{ProductMatch: m, Highlights: hl}
+	}
+	return c.JSON(fiber.Map{"items": items, "total": total, "page": page, "limit": limit})
+}
+
+// searchTerms lowercases the words of a text query, dropping negated ones and
+// single characters. Quotes and other punctuation only matter to Mongo, not
+// to highlighting.
+func searchTerms(q string) []string {
+	var terms []string
+	for _, f := range strings.Fields(strings.ToLower(q)) {
+		if strings.HasPrefix(f, "-") {
+			continue
+		}
+		for _, w := range strings.FieldsFunc(f, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(w)) > 1 {
+				terms = append(terms, w)
+			}
+		}
+	}
+	return terms
+}
+
+// termMatches approximates the stemming the text index applies, so a search
+// for "shoe" also marks "shoes" and one for "chargers" marks "charger".
+func termMatches(word string, terms []string) bool {
+	for _, t := range terms {
+		if strings.HasPrefix(word, t) || (len(word) >= 4 && strings.HasPrefix(t, word)) {
+			return true
+		}
+	}
+	return false
+}
+
+type span struct{ start, end int }
+
+// matchSpans returns the byte ranges of the words in text that match terms.
+func matchSpans(text string, terms []string) []span {
+	var out []span
+	start := -1
+	flush := func(end int) {
+		if start >= 0 && termMatches(strings.ToLower(text[start:end]), terms) {
+			out = append(out, span{start, end})
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+	return out
+}
+
+// snippet cuts text down to the first match plus about width bytes either
+// side, breaking at spaces, and marks every match in what is left. It
+// returns "" when nothing matches.
+func snippet(text string, terms []string, width int) string {
+	spans := matchSpans(text, terms)
+	if len(spans) == 0 {
+		return ""
+	}
+	first := spans[0]
+	from, to := 0, len(text)
+	if f := first.start - width; f > 0 {
+		if i := strings.IndexByte(text[f:first.start], ' '); i >= 0 {
+			from = f + i + 1
+		} else {
+			from = first.start
+		}
+	}
+	if t := first.end + width; t < len(text) {
+		if i := strings.LastIndexByte(text[first.end:t], ' '); i >= 0 {
+			to = first.end + i
+		} else {
+			to = first.end
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	last := from
+	for _, s := range spans {
+		if s.end > to {
+			break
+		}
+		b.WriteString(html.EscapeString(text[last:s.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[s.start:s.end]))
+		b.WriteString("</mark>")
+		last = s.end
+	}
+	b.WriteString(html.EscapeString(text[last:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
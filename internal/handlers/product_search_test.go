@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"Running Shoes", []string{"running", "shoes"}},
+		{`"trail shoes" -leather`, []string{"trail", "shoes"}},
+		{"a b cd", []string{"cd"}},
+		{"wi-fi", []string{"wi", "fi"}},
+		{"Café CRÈME", []string{"café", "crème"}},
+		{"<script>", []string{"script"}},
+		{"-x", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := searchTerms(tt.q); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("searchTerms(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestTermMatches(t *testing.T) {
+	tests := []struct {
+		word  string
+		terms []string
+		want  bool
+	}{
+		{"shoes", []string{"shoe"}, true},
+		{"charger", []string{"chargers"}, true},
+		{"shoe", []string{"boot", "shoe"}, true},
+		{"boot", []string{"shoe"}, false},
+		{"sh", []string{"shoe"}, false},     // too short to stand for a longer term
+		{"the", []string{"theater"}, false}, // likewise
+		{"shoe", nil, false},
+	}
+	for _, tt := range tests {
+		if got := termMatches(tt.word, tt.terms); got != tt.want {
+			t.Errorf("termMatches(%q, %q) = %v, want %v", tt.word, tt.terms, got, tt.want)
+		}
+	}
+}
+
+func TestMatchSpans(t *testing.T) {
+	tests := []struct {
+		text  string
+		terms []string
+		want  []span
+	}{
+		{"Red shoes, blue SHOES!", []string{"shoe"}, []span{{4, 9}, {16, 21}}},
+		{"Crème brûlée", []string{"brûlée"}, []span{{7, 15}}},
+		{"shoe", []string{"shoe"}, []span{{0, 4}}},
+		{"no match here", []string{"shoe"}, nil},
+		{"", []string{"shoe"}, nil},
+	}
+	for _, tt := range tests {
+		if got := matchSpans(tt.text, tt.terms); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("matchSpans(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+		width int
+		want  string
+	}{
+		{"no match", "plain socks", []string{"shoe"}, 80, ""},
+		{"whole name", "Trail Running Shoes", []string{"running", "shoes"}, 19,
+			"Trail <mark>Running</mark> <mark>Shoes</mark>"},
+		{"markup in the text is escaped", `<b>Shoes</b> & "socks"`, []string{"shoes", "socks"}, 100,
+			`&lt;b&gt;<mark>Shoes</mark>&lt;/b&gt; &amp; &#34;<mark>socks</mark>&#34;`},
+		{"script tags are escaped around marks", "<script>alert(1)</script> shoe", []string{"script"}, 100,
+			"&lt;<mark>script</mark>&gt;alert(1)&lt;/<mark>script</mark>&gt; shoe"},
+		{"cut at spaces either side", "one two three four five six seven eight nine ten", []string{"five"}, 10,
+			"…four <mark>five</mark> six…"},
+		{"matches past the cut are dropped", "shoe a b c d e f g shoe", []string{"shoe"}, 4,
+			"<mark>shoe</mark> a…"},
+		{"no space in the window", "xxxxxxxxxx-shoe-yyyyyyyyyy", []string{"shoe"}, 3,
+			"…<mark>shoe</mark>…"},
+		{"cut inside a multi-byte rune", "ééééé shoe ééééé", []string{"shoe"}, 2,
+			"…<mark>shoe</mark>…"},
+		{"multi-byte match", "Crème brûlée maison", []string{"brûlée"}, 100,
+			"Crème <mark>brûlée</mark> maison"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := snippet(tt.text, tt.terms, tt.width)
+			if got != tt.want {
+				t.Fatalf("got  %q\nwant %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Fatalf("invalid UTF-8: %q", got)
+			}
+			// the only markup that gets through is our own
+			bare := strings.NewReplacer("<mark>", "", "</mark>", "").Replace(got)
+			if strings.ContainsAny(bare, `<>"`) {
+				t.Fatalf("unescaped markup in %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/mailer"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/middleware"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Me returns the logged-in user's profile.
+func (h *AuthHandler) Me(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, _, err := h.currentUser(ctx, c)
+	if err != nil || user == nil {
+		return err
+	}
+	return c.JSON(user)
+}
+
+// UpdateMe edits profile fields. The email address has its own confirmed flow,
+// see ChangeEmail.
+func (h *AuthHandler) UpdateMe(c *fiber.Ctx) error {
+	// nil fields were absent from the body and are left unchanged
+	var req struct {
+		Name *string `json:"name" validate:"omitempty,min=1,max=100"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+	update := bson.M{}
+	if req.Name != nil {
+		update["name"] = *req.Name
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, uid, err := h.currentUser(ctx, c)
+	if err != nil || user == nil {
+		return err
+	}
+	if len(update) == 0 {
+		return c.JSON(user)
+	}
+	user, err = h.UserRepo.Update(ctx, uid, update)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(user)
+}
+
+// ChangePassword sets a new password after checking the current one, and signs
+// out every other session.
+func (h *AuthHandler) ChangePassword(c *fiber.Ctx) error {
+	var req struct {
+		CurrentPassword string `json:"current_password" validate:"required"`
+		NewPassword     string `json:"new_password" validate:"required,min=8,max=72"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, uid, err := h.currentUser(ctx, c)
+	if err != nil || user == nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)) != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid credentials"})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.UserRepo.UpdatePassword(ctx, uid, string(hash)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	current := middleware.GetClaims(c).SessionID
+	if err := h.sessions.revokeAll(ctx, uid, current); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	// a reset link mailed earlier would undo the change
+	if err := h.OneTimeTokens.InvalidateForUser(ctx, uid, models.PurposePasswordReset); err != nil {
+		log.Printf("invalidate reset tokens for %s: %v", user.ID.Hex(), err)
+	}
+	return c.JSON(fiber.Map{"message": "password updated"})
+}
+
+// ChangeEmail mails a confirmation link to the new address. The account keeps
+// its current email until the link is opened.
+func (h *AuthHandler) ChangeEmail(c *fiber.Ctx) error {
+	var req struct {
+		Email    string `json:"email" validate:"required,email,max=254"`
+		Password string `json:"password" validate:"required"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	user, uid, err := h.currentUser(ctx, c)
+	if err != nil || user == nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid credentials"})
+	}
+	if strings.EqualFold(req.Email, user.Email) {
+		return c.Status(409).JSON(fiber.Map{"error": "that is already your email"})
+	}
+	other, err := h.UserRepo.FindByEmail(ctx, req.Email)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if other != nil {
+		return c.Status(409).JSON(fiber.Map{"error": repo.ErrEmailExists.Error()})
+	}
+
+	if err := h.links.send(ctx, link{
+		userID:  uid,
+		to:      req.Email,
+		email:   req.Email,
+		purpose: models.PurposeEmailChange,
+		ttl:     h.EmailVerifyTTL,
+		path:    "/api/me/email/confirm",
+		subject: "Confirm your new email address",
+		intro:   "Someone asked to move an account to this email address.",
+	}); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(202).JSON(fiber.Map{"message": "confirmation email sent to the new address"})
+}
+
+// ConfirmEmailChange consumes the token from ChangeEmail and switches the
+// account over. The old address is told about the change.
+func (h *AuthHandler) ConfirmEmailChange(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "token required"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	t, err := h.OneTimeTokens.Consume(ctx, hashToken(token), models.PurposeEmailChange)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if t == nil || t.Email == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+	user, err := h.UserRepo.FindByID(ctx, t.UserID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+
+	if err := h.UserRepo.UpdateEmail(ctx, t.UserID, t.Email); err != nil {
+		if err == repo.ErrEmailExists {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	h.claimGuestOrders(ctx, user.ID, t.Email)
+	if err := h.links.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your email address was changed",
+		Body:    "The email address on your account was changed to " + t.Email + ".\nIf it wasn't you, reset your password and contact support.",
+	}); err != nil {
+		log.Printf("email change notice for %s: %v", user.ID.Hex(), err)
+	}
+	return c.JSON(fiber.Map{"message": "email updated"})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/middleware"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sessionStore keeps session records and their refresh token families in
+// step, so revoking a session also stops it from being refreshed.
+type sessionStore struct {
+	records *repo.SessionRepo
+	refresh *repo.RefreshTokenRepo
+}
+
+// revoke ends one session. It reports false if the user had no such live session.
+func (s *sessionStore) revoke(ctx context.Context, userID, id primitive.ObjectID) (bool, error) {
+	ok, err := s.records.Revoke(ctx, userID, id)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, s.refresh.RevokeFamily(ctx, id)
+}
+
+// end revokes a session and its refresh tokens even if there is no session
+// record, as for logins from before sessions were recorded.
+func (s *sessionStore) end(ctx context.Context, userID, id primitive.ObjectID) error {
+	if _, err := s.records.Revoke(ctx, userID, id); err != nil {
+		return err
+	}
+	return s.refresh.RevokeFamily(ctx, id)
+}
+
+// revokeAll ends every session of the user except keep, which may be
+// primitive.NilObjectID.
+func (s *sessionStore) revokeAll(ctx context.Context, userID, keep primitive.ObjectID) error {
+	if err := s.records.RevokeAllForUser(ctx, userID, keep); err != nil {
+		return err
+	}
+	if keep.IsZero() {
+		return s.refresh.RevokeAllForUser(ctx, userID)
+	}
+	return s.refresh.RevokeAllForUserExcept(ctx, userID, keep)
+}
+
+// startSession records a new login from this client and issues its tokens.
+func (h *AuthHandler) startSession(ctx context.Context, c *fiber.Ctx, user *models.User) (fiber.Map, error) {
+	uid := user.ID
+	s := &models.Session{
+		ID:        primitive.NewObjectID(),
+		UserID:    uid,
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IP:        c.IP(),
+		ExpiresAt: time.Now().Add(h.RefreshTokenTTL).UTC(),
+	}
+	if err := h.sessions.records.Create(ctx, s); err != nil {
+		return nil, err
+	}
+	// catches guest orders placed since the address was verified
+	if user.EmailVerified {
+		h.claimGuestOrders(ctx, uid, user.Email)
+	}
+	return h.issueTokens(ctx, user, s.ID)
+}
+
+type sessionView struct {
+	models.Session
+	Current bool `json:"current"` // the session making this request
+}
+
+// ListSessions shows where the logged-in user is signed in.
+func (h *AuthHandler) ListSessions(c *fiber.Ctx) error {
+	claims := middleware.GetClaims(c)
+	uid := claims.UserID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sessions, err := h.sessions.records.ListActive(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	out := make([]sessionView, len(sessions))
+	for i, s := range sessions {
+		out[i] = sessionView{Session: s, Current: s.ID == claims.SessionID}
+	}
+	return c.JSON(out)
+}
+
+// RevokeSession signs one of the user's sessions out. Its access token stops
+// working on the next request.
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	sid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	uid := middleware.GetClaims(c).UserID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ok, err := h.sessions.revoke(ctx, uid, sid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendStatus(204)
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/middleware"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/totp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const recoveryCodeCount = 10
+
+// EnrollTOTP starts 2FA enrollment by generating a secret for the user to add
+// to their authenticator app. 2FA is only switched on once ConfirmTOTP sees a
+// valid code for it.
+func (h *AuthHandler) EnrollTOTP(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, uid, err := h.currentUser(ctx, c)
+	if err != nil || user == nil {
+		return err
+	}
+	if user.TOTPEnabled {
+		return c.Status(409).JSON(fiber.Map{"error": "two-factor authentication is already enabled"})
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.UserRepo.SetPendingTOTP(ctx, uid, secret); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(h.TOTPIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTOTP enables 2FA and returns the recovery codes. They are only ever
+// shown here.
+func (h *AuthHandler) ConfirmTOTP(c *fiber.Ctx) error {
+	var req struct {
+		Code string `json:"code" validate:"required"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, uid, err := h.currentUser(ctx, c)
+	if err != nil || user == nil {
+		return err
+	}
+	if user.TOTPPendingSecret == "" {
+		return c.Status(409).JSON(fiber.Map{"error": "no enrollment in progress"})
+	}
+	step, ok := totp.Validate(user.TOTPPendingSecret, req.Code, time.Now(), 1)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid code"})
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	enabled, err := h.UserRepo.EnableTOTP(ctx, uid, user.TOTPPendingSecret, step, hashes)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !enabled {
+		return c.Status(409).JSON(fiber.Map{"error": "enrollment changed, start again"})
+	}
+	return c.JSON(fiber.Map{"message": "two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// DisableTOTP turns 2FA off. It needs both the password and a current code or
+// recovery code.
+func (h *AuthHandler) DisableTOTP(c *fiber.Ctx) error {
+	var req struct {
+		Password     string `json:"password" validate:"required"`
+		Code         string `json:"code" validate:"required_without=RecoveryCode"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, uid, err := h.currentUser(ctx, c)
+	if err != nil || user == nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return c.Status(409).JSON(fiber.Map{"error": "two-factor authentication is not enabled"})
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid credentials"})
+	}
+	ok, err := h.checkSecondFactor(ctx, user, uid, req.Code, req.RecoveryCode)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid code"})
+	}
+	if err := h.UserRepo.DisableTOTP(ctx, uid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "two-factor authentication disabled"})
+}
+
+// LoginMFA completes a login for a 2FA user: it trades the challenge token
+// from Login plus a TOTP or recovery code for the usual token pair.
+func (h *AuthHandler) LoginMFA(c *fiber.Ctx) error {
+	var req struct {
+		MFAToken     string `json:"mfa_token" validate:"required"`
+		Code         string `json:"code" validate:"required_without=RecoveryCode"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if ok, err := bindJSON(c, &req); !ok {
+		return err
+	}
+
+	uid, err := h.parseMFAToken(req.MFAToken)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid or expired mfa_token"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.UserRepo.FindByID(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil || !user.TOTPEnabled {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid or expired mfa_token"})
+	}
+	if msg := loginBlocked(user); msg != "" {
+		return c.Status(403).JSON(fiber.Map{"error": msg})
+	}
+
+	// codes are short, so they share the password throttle
+	ip := c.IP()
+	wait, err := h.Guard.Wait(ctx, user.Email, ip)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if wait > 0 {
+		secs := int(math.Ceil(wait.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(secs))
+		return c.Status(429).JSON(fiber.Map{"error": "too many failed login attempts, try again later", "retry_after": secs})
+	}
+
+	ok, err := h.checkSecondFactor(ctx, user, uid, req.Code, req.RecoveryCode)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		if err := h.Guard.Fail(ctx, user.Email, ip); err != nil {
+			log.Printf("record failed login: %v", err)
+		}
+		return c.Status(400).JSON(fiber.Map{"error": "invalid code"})
+	}
+	if err := h.Guard.Succeed(ctx, user.Email); err != nil {
+		log.Printf("clear failed logins: %v", err)
+	}
+
+	tokens, err := h.startSession(ctx, c, user)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(tokens)
+}
+
+// checkSecondFactor accepts either a TOTP code, which can't be reused, or an
+// unused recovery code, which is consumed.
+func (h *AuthHandler) checkSecondFactor(ctx context.Context, user *models.User, uid primitive.ObjectID, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), 1)
+		if !ok {
+			return false, nil
+		}
+		return h.UserRepo.UseTOTPStep(ctx, uid, step)
+	}
+	return h.UserRepo.UseRecoveryCode(ctx, uid, hashRecoveryCode(recoveryCode))
+}
+
+// mfaChallenge answers a correct password for a 2FA user with a short-lived
+// token that only LoginMFA accepts.
+func (h *AuthHandler) mfaChallenge(c *fiber.Ctx, user *models.User) error {
+	tokenStr, err := h.Keys.Sign(jwt.MapClaims{
+		"user_id": user.ID.Hex(),
+		"typ":     "mfa",
+		"exp":     time.Now().Add(h.MFATokenTTL).Unix(),
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{
+		"mfa_required": true,
+		"mfa_token":    tokenStr,
+		"expires_in":   int(h.MFATokenTTL.Seconds()),
+	})
+}
+
+func (h *AuthHandler) parseMFAToken(tokenStr string) (primitive.ObjectID, error) {
+	mc, err := h.Keys.Parse(tokenStr)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if typ, _ := mc["typ"].(string); typ != "mfa" {
+		return primitive.NilObjectID, fmt.Errorf("not an mfa token")
+	}
+	userID, _ := mc["user_id"].(string)
+	return primitive.ObjectIDFromHex(userID)
+}
+
+// currentUser loads the authenticated user. On failure the response has been
+// written and the returned error must be passed back to fiber.
+func (h *AuthHandler) currentUser(ctx context.Context, c *fiber.Ctx) (*models.User, primitive.ObjectID, error) {
+	uid := middleware.GetClaims(c).UserID
+	user, err := h.UserRepo.FindByID(ctx, uid)
+	if err != nil {
+		return nil, uid, c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return nil, uid, c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return user, uid, nil
+}
+
+// newRecoveryCodes returns codes formatted for display ("abcde-fghij") and the
+// hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
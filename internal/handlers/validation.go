@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/validate"
+)
+
+// bindJSON parses the request body into req and validates it. When ok is false
+// the 400/422 response has already been written and err must be returned as is.
+func bindJSON(c *fiber.Ctx, req interface{}) (ok bool, err error) {
+	if err := c.BodyParser(req); err != nil {
+		return false, c.Status(400).JSON(fiber.Map{"error": "invalid body"})
+	}
+	if errs := validate.Struct(req); len(errs) > 0 {
+		return false, c.Status(422).JSON(fiber.Map{"error": "validation failed", "fields": errs})
+	}
+	return true, nil
+}
+
+// bindQuery is bindJSON for the query string, filling req through its `query`
+// tags.
+func bindQuery(c *fiber.Ctx, req interface{}) (ok bool, err error) {
+	if err := c.QueryParser(req); err != nil {
+		return false, c.Status(400).JSON(fiber.Map{"error": "invalid query"})
+	}
+	if errs := validate.Struct(req); len(errs) > 0 {
+		return false, c.Status(422).JSON(fiber.Map{"error": "validation failed", "fields": errs})
+	}
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type bindRequest struct {
+	Email string `json:"email" query:"email" validate:"required,email"`
+	Qty   int    `json:"qty" query:"qty" validate:"min=1"`
+}
+
+func bindApp() *fiber.App {
+	app := fiber.New()
+	app.Post("/json", func(c *fiber.Ctx) error {
+		var req bindRequest
+		if ok, err := bindJSON(c, &req); !ok {
+			return err
+		}
+		return c.JSON(req)
+	})
+	app.Get("/query", func(c *fiber.Ctx) error {
+		var req bindRequest
+		if ok, err := bindQuery(c, &req); !ok {
+			return err
+		}
+		return c.JSON(req)
+	})
+	return app
+}
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"valid body", "POST", "/json", `{"email":"a@example.com","qty":2}`, 200,
+			`{"email":"a@example.com","qty":2}`},
+		{"malformed JSON", "POST", "/json", `{"email":`, 400,
+			`{"error":"invalid body"}`},
+		{"wrong JSON type", "POST", "/json", `{"email":"a@example.com","qty":"two"}`, 400,
+			`{"error":"invalid body"}`},
+		{"validation failure", "POST", "/json", `{"email":"nope","qty":0}`, 422,
+			`{"error":"validation failed","fields":[
+				{"field":"email","rule":"email","message":"must be a valid email address"},
+				{"field":"qty","rule":"min","param":"1","message":"must be at least 1"}]}`},
+		{"missing field", "POST", "/json", `{"qty":1}`, 422,
+			`{"error":"validation failed","fields":[{"field":"email","rule":"required","message":"is required"}]}`},
+		{"valid query", "GET", "/query?email=a@example.com&qty=3", "", 200,
+			`{"email":"a@example.com","qty":3}`},
+		{"unparseable query", "GET", "/query?email=a@example.com&qty=three", "", 400,
+			`{"error":"invalid query"}`},
+		{"invalid query", "GET", "/query?qty=1", "", 422,
+			`{"error":"validation failed","fields":[{"field":"email","rule":"required","message":"is required"}]}`},
+	}
+	app := bindApp()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			raw, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(raw, &got); err != nil {
+				t.Fatalf("response %q: %v", raw, err)
+			}
+			if err := json.Unmarshal([]byte(tt.wantBody), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("body %s\nwant %s", raw, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+// Package jwtkeys holds the keys used to sign and verify our JWTs.
+//
+// Tokens are signed with a single active key, RS256 or EdDSA when a private key
+// file is configured and HS256 with JWT_SECRET otherwise. Asymmetric tokens
+// carry a kid header. Retired public keys can stay in the set so tokens signed
+// before a rotation keep verifying, and the public half of every asymmetric key
+// is published as a JWKS for other services. Once a signing key is configured
+// HS256 tokens are only accepted while AcceptLegacyHS256 is set.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Key is one verification key, plus its private half if it signs.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Public  crypto.PublicKey // *rsa.PublicKey or ed25519.PublicKey
+	private crypto.PrivateKey
+}
+
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	secret  []byte // HS256, for tokens without a kid
+}
+
+type Config struct {
+	Secret         string
+	SigningKeyFile string
+	SigningKeyID   string   // defaults to the key's RFC 7638 thumbprint
+	VerifyKeyFiles []string // "path" or "kid=path"; public or private PEM
+	// AcceptLegacyHS256 keeps HS256 tokens signed with Secret valid alongside
+	// a signing key, while moving off the shared secret.
+	AcceptLegacyHS256 bool
+}
+
+func Load(cfg Config) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]*Key{}}
+	if cfg.Secret != "" {
+		ks.secret = []byte(cfg.Secret)
+	}
+
+	if cfg.SigningKeyFile != "" {
+		k, err := loadKey(cfg.SigningKeyFile, cfg.SigningKeyID, true)
+		if err != nil {
+			return nil, fmt.Errorf("signing key: %w", err)
+		}
+		ks.signing = k
+		ks.keys[k.ID] = k
+		if !cfg.AcceptLegacyHS256 {
+			ks.secret = nil
+		}
+	} else if ks.secret == nil {
+		return nil, errors.New("either JWT_SIGNING_KEY_FILE or JWT_SECRET must be set")
+	}
+
+	for _, spec := range cfg.VerifyKeyFiles {
+		kid, path := "", spec
+		if i := strings.IndexByte(spec, '='); i >= 0 {
+			kid, path = spec[:i], spec[i+1:]
+		}
+		k, err := loadKey(path, kid, false)
+		if err != nil {
+			return nil, fmt.Errorf("verify key %s: %w", path, err)
+		}
+		if _, dup := ks.keys[k.ID]; dup {
+			continue // e.g. the signing key listed again
+		}
+		ks.keys[k.ID] = k
+	}
+	return ks, nil
+}
+
+// Sign signs claims with the active key.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.secret)
+	}
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.private)
+}
+
+// Parse verifies tokenStr against the key named by its kid header, or against
+// the HS256 secret if it has none, and returns its claims.
+func (ks *KeySet) Parse(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, ks.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	mc, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return mc, nil
+}
+
+func (ks *KeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok || ks.secret == nil {
+			return nil, errors.New("unexpected signing method")
+		}
+		return ks.secret, nil
+	}
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	// never let the token pick the algorithm for a key
+	if t.Method.Alg() != k.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return k.Public, nil
+}
+
+// JWK is a public key in RFC 7517 form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every asymmetric key, signing key first.
+func (ks *KeySet) JWKS() JWKS {
+	out := JWKS{Keys: []JWK{}}
+	if ks.signing != nil {
+		out.Keys = append(out.Keys, toJWK(ks.signing))
+	}
+	for id, k := range ks.keys {
+		if ks.signing != nil && id == ks.signing.ID {
+			continue
+		}
+		out.Keys = append(out.Keys, toJWK(k))
+	}
+	return out
+}
+
+func toJWK(k *Key) JWK {
+	j := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = b64(pub.N.Bytes())
+		j.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		j.Kty = "OKP"
+		j.Crv = "Ed25519"
+		j.X = b64(pub)
+	}
+	return j
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint, used as the default kid.
+func thumbprint(k *Key) string {
+	j := toJWK(k)
+	var members interface{}
+	switch j.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	}
+	b, _ := json.Marshal(members)
+	sum := sha256.Sum256(b)
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// loadKey reads a PEM key. Private keys are accepted for verification too;
+// only their public half is kept unless needPrivate is set.
+func loadKey(path, kid string, needPrivate bool) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	k := &Key{}
+	switch block.Type {
+	case "PUBLIC KEY", "RSA PUBLIC KEY":
+		if needPrivate {
+			return nil, errors.New("a private key is required for signing")
+		}
+		var pub interface{}
+		if block.Type == "RSA PUBLIC KEY" {
+			pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		} else {
+			pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+		k.Public = pub
+	case "PRIVATE KEY", "RSA PRIVATE KEY":
+		var priv interface{}
+		if block.Type == "RSA PRIVATE KEY" {
+			priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		} else {
+			priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key")
+		}
+		k.Public = signer.Public()
+		if needPrivate {
+			k.private = priv
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+
+	switch k.Public.(type) {
+	case *rsa.PublicKey:
+		k.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		k.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+
+	k.ID = kid
+	if k.ID == "" {
+		k.ID = thumbprint(k)
+	}
+	return k, nil
+}
@@ -0,0 +1,70 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func writeEd25519Key(t *testing.T) string {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLegacyHS256(t *testing.T) {
+	claims := jwt.MapClaims{"user_id": "u1", "exp": time.Now().Add(time.Minute).Unix()}
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeEd25519Key(t)
+
+	tests := []struct {
+		name   string
+		cfg    Config
+		wantOK bool
+	}{
+		{"secret only", Config{Secret: "s3cret"}, true},
+		{"signing key replaces the secret", Config{Secret: "s3cret", SigningKeyFile: keyFile}, false},
+		{"explicit transition", Config{Secret: "s3cret", SigningKeyFile: keyFile, AcceptLegacyHS256: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := Load(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ks.Parse(legacy); (err == nil) != tt.wantOK {
+				t.Fatalf("Parse: %v, want ok=%v", err, tt.wantOK)
+			}
+
+			// whatever the active key signs always verifies
+			signed, err := ks.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ks.Parse(signed); err != nil {
+				t.Fatalf("Parse own token: %v", err)
+			}
+		})
+	}
+}
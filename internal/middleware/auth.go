@@ -1,32 +1,205 @@
 package middleware
 
 import (
-	"fmt"
-	"os"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/jwtkeys"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func RequireAuth() fiber.Handler {
-	secret := []byte(os.Getenv("JWT_SECRET"))
+const claimsKey = "claims"
+
+// Claims is the identity extracted from a verified token or API key. Exactly
+// one of UserID and APIKeyID is set.
+type Claims struct {
+	UserID    primitive.ObjectID
+	Role      string
+	SessionID primitive.ObjectID // also the refresh token family
+	APIKeyID  primitive.ObjectID
+	Scopes    []models.Scope
+}
+
+func (cl *Claims) IsAdmin() bool {
+	return cl != nil && cl.Role == models.RoleAdmin
+}
+
+func (cl *Claims) IsAPIKey() bool {
+	return cl != nil && !cl.APIKeyID.IsZero()
+}
+
+func (cl *Claims) HasScope(s models.Scope) bool {
+	if cl == nil {
+		return false
+	}
+	for _, have := range cl.Scopes {
+		if have == s {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClaims returns the claims stored by RequireAuth, or nil on public routes.
+func GetClaims(c *fiber.Ctx) *Claims {
+	cl, _ := c.Locals(claimsKey).(*Claims)
+	return cl
+}
+
+// AuthConfig carries what the auth middleware needs to verify a request.
+type AuthConfig struct {
+	Keys     *jwtkeys.KeySet
+	APIKeys  *repo.APIKeyRepo
+	Users    *repo.UserRepo    // checked so disabled accounts lose access at once
+	Sessions *repo.SessionRepo // and so do revoked sessions
+}
+
+// RequireAuth accepts bearer JWTs only.
+func RequireAuth(cfg AuthConfig) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		auth := c.Get("Authorization")
-		if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
-			return c.Status(401).JSON(fiber.Map{"error": "missing or invalid Authorization header"})
+		return authenticateJWT(c, cfg)
+	}
+}
+
+// RequireAuthOrAPIKey also accepts an X-API-Key header, for routes that
+// service integrations call. Pair it with RequireScope.
+func RequireAuthOrAPIKey(cfg AuthConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get("X-API-Key")
+		if key == "" {
+			return authenticateJWT(c, cfg)
 		}
-		tokenStr := strings.TrimPrefix(auth, "Bearer ")
 
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return secret, nil
-		})
-		if err != nil || !token.Valid {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid token"})
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		sum := sha256.Sum256([]byte(key))
+		k, err := cfg.APIKeys.FindByHash(ctx, hex.EncodeToString(sum[:]))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if k == nil || k.RevokedAt != nil || (k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)) {
+			return c.Status(401).JSON(fiber.Map{"error": "invalid api key"})
+		}
+		if err := cfg.APIKeys.Touch(ctx, k.ID); err != nil {
+			log.Printf("touch api key %s: %v", k.ID.Hex(), err)
 		}
+		c.Locals(claimsKey, &Claims{APIKeyID: k.ID, Scopes: k.Scopes})
 		return c.Next()
 	}
 }
+
+func authenticateJWT(c *fiber.Ctx, cfg AuthConfig) error {
+	auth := c.Get("Authorization")
+	if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
+		return c.Status(401).JSON(fiber.Map{"error": "missing or invalid Authorization header"})
+	}
+	tokenStr := strings.TrimPrefix(auth, "Bearer ")
+
+	mc, err := cfg.Keys.Parse(tokenStr)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid token"})
+	}
+	// only access tokens authenticate requests; MFA challenge tokens don't
+	if typ, _ := mc["typ"].(string); typ != "" && typ != "access" {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid token"})
+	}
+	userID, _ := mc["user_id"].(string)
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid token"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	user, err := cfg.Users.FindByID(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user == nil {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid token"})
+	}
+	if user.Disabled {
+		return c.Status(403).JSON(fiber.Map{"error": "account disabled"})
+	}
+	if user.PasswordResetRequired {
+		return c.Status(401).JSON(fiber.Map{"error": "password reset required"})
+	}
+
+	// the stored role wins so promotions and demotions apply immediately
+	role := user.Role
+	if role == "" {
+		role = models.RoleCustomer // users created before roles existed
+	}
+	sid, _ := mc["sid"].(string)
+	sessionID, err := primitive.ObjectIDFromHex(sid)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid token"})
+	}
+	s, err := cfg.Sessions.FindByID(ctx, sessionID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if s == nil || s.UserID != uid || !s.Active(time.Now()) {
+		return c.Status(401).JSON(fiber.Map{"error": "session revoked"})
+	}
+	// the session was just read, so skip the round trip when Touch would
+	// match nothing anyway
+	if time.Since(s.LastSeenAt) >= repo.TouchInterval {
+		if err := cfg.Sessions.Touch(ctx, s.ID, c.IP(), c.Get(fiber.HeaderUserAgent), time.Time{}); err != nil {
+			log.Printf("touch session %s: %v", s.ID.Hex(), err)
+		}
+	}
+
+	c.Locals(claimsKey, &Claims{UserID: uid, Role: role, SessionID: sessionID})
+	return c.Next()
+}
+
+// RequireRole must run after RequireAuth and rejects callers whose role is not listed.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cl := GetClaims(c)
+		if cl == nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+		for _, r := range roles {
+			if cl.Role == r {
+				return c.Next()
+			}
+		}
+		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+}
+
+// RequireScope must run after RequireAuthOrAPIKey. API keys need scope; users
+// need one of roles, or any role if none are listed.
+func RequireScope(scope models.Scope, roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cl := GetClaims(c)
+		if cl == nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+		if cl.IsAPIKey() {
+			if cl.HasScope(scope) {
+				return c.Next()
+			}
+			return c.Status(403).JSON(fiber.Map{"error": "api key lacks scope " + string(scope)})
+		}
+		if len(roles) == 0 {
+			return c.Next()
+		}
+		for _, r := range roles {
+			if cl.Role == r {
+				return c.Next()
+			}
+		}
+		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+}
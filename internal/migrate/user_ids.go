@@ -0,0 +1,286 @@
+// Package migrate holds one-off data migrations. Each is safe to run again.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// userRefCollections store a user_id that must match the user's _id.
+var userRefCollections = []string{"orders", "refresh_tokens", "one_time_tokens", "sessions"}
+
+// UserIDReport says what UserIDs changed, or would change in a dry run.
+type UserIDReport struct {
+	UsersRekeyed int            // users whose string _id became an ObjectID
+	RefsFixed    map[string]int // per collection, user_id values rewritten
+	OrphanOrders int64          // orders whose user_id matches no user afterwards
+}
+
+func (r *UserIDReport) String() string {
+	return fmt.Sprintf("users re-keyed: %d, references fixed: %v, orphan orders: %d", r.UsersRekeyed, r.RefsFixed, r.OrphanOrders)
+}
+
+// UserIDs makes every user _id an ObjectID and every user_id reference an
+// ObjectID pointing at it.
+//
+// Users stored with a string _id are re-inserted under an ObjectID: the same
+// value when the string is hex, a new one otherwise. The old value is kept in
+// legacy_id and references to it are moved over, so an interrupted run picks
+// up where it stopped. Each swap is atomic or backed up, so no user is lost.
+// Remaining string user_id values that are valid hex are then converted in
+// place.
+func UserIDs(ctx context.Context, db *mongo.Database, dryRun bool) (*UserIDReport, error) {
+	rep := &UserIDReport{RefsFixed: map[string]int{}}
+	users := db.Collection("users")
+
+	if !dryRun {
+		if err := restoreBackups(ctx, db); err != nil {
+			return rep, err
+		}
+	}
+
+	cur, err := users.Find(ctx, bson.M{"_id": bson.M{"$type": "string"}})
+	if err != nil {
+		return nil, err
+	}
+	var stringKeyed []bson.M
+	if err := cur.All(ctx, &stringKeyed); err != nil {
+		return nil, err
+	}
+
+	for _, doc := range stringKeyed {
+		oldID := doc["_id"].(string)
+		newID, err := primitive.ObjectIDFromHex(oldID)
+		if err != nil {
+			newID = primitive.NewObjectID()
+		}
+		log.Printf("user %q -> %s", oldID, newID.Hex())
+		rep.UsersRekeyed++
+
+		if dryRun {
+			if err := moveAllRefs(ctx, db, oldID, newID, true, rep); err != nil {
+				return rep, err
+			}
+			continue
+		}
+		if err := rekey(ctx, db, doc, newID); err != nil {
+			return rep, fmt.Errorf("user %q: %w", oldID, err)
+		}
+	}
+
+	if !dryRun {
+		cur, err := users.Find(ctx, bson.M{"legacy_id": bson.M{"$exists": true}})
+		if err != nil {
+			return rep, err
+		}
+		var rekeyed []struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			LegacyID string             `bson:"legacy_id"`
+		}
+		if err := cur.All(ctx, &rekeyed); err != nil {
+			return rep, err
+		}
+		for _, u := range rekeyed {
+			if err := moveAllRefs(ctx, db, u.LegacyID, u.ID, false, rep); err != nil {
+				return rep, err
+			}
+		}
+	}
+
+	for _, name := range userRefCollections {
+		n, err := convertHexRefs(ctx, db.Collection(name), dryRun)
+		if err != nil {
+			return rep, fmt.Errorf("%s: %w", name, err)
+		}
+		rep.RefsFixed[name] += n
+	}
+
+	if !dryRun {
+		if rep.OrphanOrders, err = countOrphanOrders(ctx, db); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+func moveAllRefs(ctx context.Context, db *mongo.Database, oldID string, newID primitive.ObjectID, dryRun bool, rep *UserIDReport) error {
+	for _, name := range userRefCollections {
+		n, err := moveRefs(ctx, db.Collection(name), oldID, newID, dryRun)
+		if err != nil {
+			return fmt.Errorf("%s refs of user %q: %w", name, oldID, err)
+		}
+		rep.RefsFixed[name] += n
+	}
+	return nil
+}
+
+// rekeyBackups holds a copy of each user while rekeyWithBackup swaps it, so a
+// run that dies between the delete and the insert loses nothing.
+const rekeyBackups = "users_rekey_backup"
+
+// rekey replaces doc with a copy under newID. The unique email index rules
+// out inserting the copy first, so the swap runs in a transaction, or on
+// servers without transactions (standalone mongod) behind a backup copy.
+func rekey(ctx context.Context, db *mongo.Database, doc bson.M, newID primitive.ObjectID) error {
+	err := rekeyInTransaction(ctx, db, doc, newID)
+	if transactionsUnsupported(err) {
+		return rekeyWithBackup(ctx, db, doc, newID)
+	}
+	return err
+}
+
+func rekeyInTransaction(ctx context.Context, db *mongo.Database, doc bson.M, newID primitive.ObjectID) error {
+	sess, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+
+	users := db.Collection("users")
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if _, err := users.DeleteOne(sc, bson.M{"_id": doc["_id"]}); err != nil {
+			return nil, err
+		}
+		_, err := users.InsertOne(sc, rekeyed(doc, newID))
+		return nil, err
+	})
+	return err
+}
+
+// transactionsUnsupported reports whether err says the server can't run
+// transactions at all, as opposed to the transaction failing.
+func transactionsUnsupported(err error) bool {
+	var ce mongo.CommandError
+	return errors.As(err, &ce) && ce.Code == 20 && strings.Contains(ce.Message, "Transaction numbers")
+}
+
+// rekeyWithBackup saves doc to rekeyBackups, swaps it and drops the backup.
+// restoreBackups finishes any swap that was cut short.
+func rekeyWithBackup(ctx context.Context, db *mongo.Database, doc bson.M, newID primitive.ObjectID) error {
+	users, backups := db.Collection("users"), db.Collection(rekeyBackups)
+	oldID := doc["_id"]
+	if _, err := backups.ReplaceOne(ctx, bson.M{"_id": oldID},
+		bson.M{"_id": oldID, "new_id": newID, "user": doc},
+		options.Replace().SetUpsert(true)); err != nil {
+		return err
+	}
+	if _, err := users.DeleteOne(ctx, bson.M{"_id": oldID}); err != nil {
+		return err
+	}
+	if _, err := users.InsertOne(ctx, rekeyed(doc, newID)); err != nil {
+		if _, rerr := users.InsertOne(ctx, doc); rerr != nil {
+			return fmt.Errorf("insert failed (%v) and restoring the original failed, a copy is kept in %s: %w", err, rekeyBackups, rerr)
+		}
+		if _, derr := backups.DeleteOne(ctx, bson.M{"_id": oldID}); derr != nil {
+			log.Printf("drop rekey backup of %v: %v", oldID, derr)
+		}
+		return err
+	}
+	_, err := backups.DeleteOne(ctx, bson.M{"_id": oldID})
+	return err
+}
+
+// restoreBackups finishes swaps an earlier run of rekeyWithBackup left
+// behind: a user missing under both ids is re-inserted under the new one.
+func restoreBackups(ctx context.Context, db *mongo.Database) error {
+	users, backups := db.Collection("users"), db.Collection(rekeyBackups)
+	cur, err := backups.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var pending []struct {
+		OldID interface{}        `bson:"_id"`
+		NewID primitive.ObjectID `bson:"new_id"`
+		User  bson.M             `bson:"user"`
+	}
+	if err := cur.All(ctx, &pending); err != nil {
+		return err
+	}
+	for _, b := range pending {
+		n, err := users.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": bson.A{b.OldID, b.NewID}}})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			log.Printf("restoring user %v from %s as %s", b.OldID, rekeyBackups, b.NewID.Hex())
+			if _, err := users.InsertOne(ctx, rekeyed(b.User, b.NewID)); err != nil {
+				return fmt.Errorf("restore user %v: %w", b.OldID, err)
+			}
+		}
+		if _, err := backups.DeleteOne(ctx, bson.M{"_id": b.OldID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rekeyed is a copy of doc under newID that remembers its old id.
+func rekeyed(doc bson.M, newID primitive.ObjectID) bson.M {
+	out := bson.M{}
+	for k, v := range doc {
+		out[k] = v
+	}
+	out["legacy_id"] = doc["_id"]
+	out["_id"] = newID
+	return out
+}
+
+func moveRefs(ctx context.Context, col *mongo.Collection, oldID string, newID primitive.ObjectID, dryRun bool) (int, error) {
+	filter := bson.M{"user_id": oldID}
+	if dryRun {
+		n, err := col.CountDocuments(ctx, filter)
+		return int(n), err
+	}
+	res, err := col.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"user_id": newID}})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.ModifiedCount), nil
+}
+
+// convertHexRefs turns string user_id values into ObjectIDs where they parse,
+// and leaves any others alone.
+func convertHexRefs(ctx context.Context, col *mongo.Collection, dryRun bool) (int, error) {
+	filter := bson.M{"user_id": bson.M{"$type": "string", "$regex": "^[0-9a-fA-F]{24}$"}}
+	if dryRun {
+		n, err := col.CountDocuments(ctx, filter)
+		return int(n), err
+	}
+	res, err := col.UpdateMany(ctx, filter, bson.A{
+		bson.M{"$set": bson.M{"user_id": bson.M{"$toObjectId": "$user_id"}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.ModifiedCount), nil
+}
+
+func countOrphanOrders(ctx context.Context, db *mongo.Database) (int64, error) {
+	cur, err := db.Collection("orders").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": bson.M{"$exists": true}}}}, // unclaimed guest orders have none
+		{{Key: "$lookup", Value: bson.M{"from": "users", "localField": "user_id", "foreignField": "_id", "as": "user"}}},
+		{{Key: "$match", Value: bson.M{"user": bson.M{"$size": 0}}}},
+		{{Key: "$count", Value: "n"}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	var out struct {
+		N int64 `bson:"n"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&out); err != nil {
+			return 0, err
+		}
+	}
+	return out.N, cur.Err()
+}
@@ -0,0 +1,217 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDB returns a fresh database on the server at MONGO_TEST_URI, dropped
+// when the test ends. Tests that need one are skipped without it.
+func testDB(t *testing.T) (context.Context, *mongo.Database) {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	client, err := db.New(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	database := client.Database("migrate_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		_ = database.Drop(context.Background())
+		_ = client.Disconnect(context.Background())
+		cancel()
+	})
+	// rekey has to cope with the unique email index the app creates
+	if _, err := database.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return ctx, database
+}
+
+func insert(t *testing.T, ctx context.Context, col *mongo.Collection, docs ...interface{}) {
+	t.Helper()
+	if _, err := col.InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func findOne(t *testing.T, ctx context.Context, col *mongo.Collection, filter bson.M) bson.M {
+	t.Helper()
+	var doc bson.M
+	if err := col.FindOne(ctx, filter).Decode(&doc); err != nil {
+		t.Fatalf("%s %v: %v", col.Name(), filter, err)
+	}
+	return doc
+}
+
+func count(t *testing.T, ctx context.Context, col *mongo.Collection, filter bson.M) int64 {
+	t.Helper()
+	n, err := col.CountDocuments(ctx, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestUserIDsRekeysHexAndNonHexIDs(t *testing.T) {
+	ctx, database := testDB(t)
+	users, orders := database.Collection("users"), database.Collection("orders")
+	sessions, refresh := database.Collection("sessions"), database.Collection("refresh_tokens")
+
+	hexID := primitive.NewObjectID()
+	keptID := primitive.NewObjectID()
+	insert(t, ctx, users,
+		bson.M{"_id": hexID.Hex(), "email": "hex@example.com"},
+		bson.M{"_id": "legacy-42", "email": "legacy@example.com"},
+		bson.M{"_id": keptID, "email": "kept@example.com"},
+	)
+	insert(t, ctx, orders,
+		bson.M{"user_id": hexID.Hex()},
+		bson.M{"user_id": "legacy-42"},
+		bson.M{"user_id": keptID.Hex()},
+	)
+	insert(t, ctx, sessions, bson.M{"user_id": "legacy-42"})
+	insert(t, ctx, refresh, bson.M{"user_id": keptID.Hex()})
+
+	rep, err := UserIDs(ctx, database, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.UsersRekeyed != 2 || rep.OrphanOrders != 0 {
+		t.Fatalf("report %s", rep)
+	}
+
+	// a hex string keeps its value as an ObjectID
+	if u := findOne(t, ctx, users, bson.M{"_id": hexID}); u["legacy_id"] != hexID.Hex() || u["email"] != "hex@example.com" {
+		t.Errorf("hex user = %v", u)
+	}
+	// anything else gets a new ObjectID and keeps its old one in legacy_id
+	legacy := findOne(t, ctx, users, bson.M{"legacy_id": "legacy-42"})
+	newID, ok := legacy["_id"].(primitive.ObjectID)
+	if !ok || legacy["email"] != "legacy@example.com" {
+		t.Fatalf("legacy user = %v", legacy)
+	}
+	if n := count(t, ctx, users, bson.M{"_id": bson.M{"$type": "string"}}); n != 0 {
+		t.Errorf("%d users still keyed by string", n)
+	}
+
+	for _, want := range []primitive.ObjectID{hexID, newID, keptID} {
+		if n := count(t, ctx, orders, bson.M{"user_id": want}); n != 1 {
+			t.Errorf("orders of %s = %d, want 1", want.Hex(), n)
+		}
+	}
+	if n := count(t, ctx, sessions, bson.M{"user_id": newID}); n != 1 {
+		t.Errorf("sessions of the re-keyed user = %d, want 1", n)
+	}
+	if n := count(t, ctx, refresh, bson.M{"user_id": keptID}); n != 1 {
+		t.Errorf("hex refresh token reference not converted")
+	}
+
+	// running again changes nothing
+	rep, err = UserIDs(ctx, database, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.UsersRekeyed != 0 {
+		t.Errorf("second run re-keyed %d users", rep.UsersRekeyed)
+	}
+	for name, n := range rep.RefsFixed {
+		if n != 0 {
+			t.Errorf("second run fixed %d %s references", n, name)
+		}
+	}
+}
+
+func TestUserIDsDryRunWritesNothing(t *testing.T) {
+	ctx, database := testDB(t)
+	users, orders := database.Collection("users"), database.Collection("orders")
+	insert(t, ctx, users, bson.M{"_id": "legacy-1", "email": "a@example.com"})
+	insert(t, ctx, orders, bson.M{"user_id": "legacy-1"}, bson.M{"user_id": "legacy-1"})
+
+	rep, err := UserIDs(ctx, database, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.UsersRekeyed != 1 || rep.RefsFixed["orders"] != 2 {
+		t.Fatalf("report %s", rep)
+	}
+	if n := count(t, ctx, users, bson.M{"_id": "legacy-1"}); n != 1 {
+		t.Error("dry run re-keyed the user")
+	}
+	if n := count(t, ctx, orders, bson.M{"user_id": "legacy-1"}); n != 2 {
+		t.Error("dry run moved orders")
+	}
+}
+
+func TestUserIDsResumesInterruptedRun(t *testing.T) {
+	ctx, database := testDB(t)
+	users, orders := database.Collection("users"), database.Collection("orders")
+	backups := database.Collection(rekeyBackups)
+
+	// cut off between deleting the old user and inserting the new one
+	lostID := primitive.NewObjectID()
+	insert(t, ctx, backups, bson.M{
+		"_id":    "legacy-7",
+		"new_id": lostID,
+		"user":   bson.M{"_id": "legacy-7", "email": "lost@example.com"},
+	})
+	// cut off after the swap, before the references moved
+	swappedID := primitive.NewObjectID()
+	insert(t, ctx, users, bson.M{"_id": swappedID, "legacy_id": "legacy-8", "email": "swapped@example.com"})
+	insert(t, ctx, orders, bson.M{"user_id": "legacy-7"}, bson.M{"user_id": "legacy-8"})
+
+	rep, err := UserIDs(ctx, database, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := findOne(t, ctx, users, bson.M{"_id": lostID}); u["legacy_id"] != "legacy-7" || u["email"] != "lost@example.com" {
+		t.Errorf("restored user = %v", u)
+	}
+	if n := count(t, ctx, backups, bson.M{}); n != 0 {
+		t.Errorf("%d backups left behind", n)
+	}
+	for _, id := range []primitive.ObjectID{lostID, swappedID} {
+		if n := count(t, ctx, orders, bson.M{"user_id": id}); n != 1 {
+			t.Errorf("orders of %s = %d, want 1", id.Hex(), n)
+		}
+	}
+	if rep.OrphanOrders != 0 {
+		t.Errorf("orphan orders = %d", rep.OrphanOrders)
+	}
+}
+
+func TestUserIDsCountsOrphanOrders(t *testing.T) {
+	ctx, database := testDB(t)
+	users, orders := database.Collection("users"), database.Collection("orders")
+	owner := primitive.NewObjectID()
+	insert(t, ctx, users, bson.M{"_id": owner, "email": "owner@example.com"})
+	insert(t, ctx, orders,
+		bson.M{"user_id": owner},
+		bson.M{"user_id": primitive.NewObjectID()},       // user deleted
+		bson.M{"user_id": primitive.NewObjectID().Hex()}, // converted, still no user
+		bson.M{"user_id": "not-a-user"},                  // neither a user nor hex
+		bson.M{"guest_email": "guest@example.com"},       // unclaimed guest order
+	)
+
+	rep, err := UserIDs(ctx, database, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.OrphanOrders != 3 {
+		t.Fatalf("orphan orders = %d, want 3", rep.OrphanOrders)
+	}
+}
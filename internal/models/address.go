@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Address is a postal address. Orders keep their own copy, so editing the
+// address book doesn't change past orders.
+type Address struct {
+	Name       string `bson:"name" json:"name"`
+	Line1      string `bson:"line1" json:"line1"`
+	Line2      string `bson:"line2,omitempty" json:"line2,omitempty"`
+	City       string `bson:"city" json:"city"`
+	State      string `bson:"state,omitempty" json:"state,omitempty"`
+	PostalCode string `bson:"postal_code" json:"postal_code"`
+	Country    string `bson:"country" json:"country"` // ISO 3166-1 alpha-2
+	Phone      string `bson:"phone,omitempty" json:"phone,omitempty"`
+}
+
+// SavedAddress is an entry in a user's address book.
+type SavedAddress struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"-"`
+	Label           string             `bson:"label,omitempty" json:"label,omitempty"` // e.g. "Home"
+	Address         `bson:",inline"`
+	DefaultShipping bool      `bson:"default_shipping" json:"default_shipping"`
+	DefaultBilling  bool      `bson:"default_billing" json:"default_billing"`
+	CreatedAt       time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Category is a node in the product category tree. Path is the materialized
+// path of ids from the root down to and including this category, as in
+// "/<root id>/<parent id>/<id>/", so a subtree is everything whose path starts
+// with the root's.
+type Category struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	Name     string             `bson:"name" json:"name"`
+	Slug     string             `bson:"slug" json:"slug"`
+	ParentID primitive.ObjectID `bson:"parent_id,omitempty" json:"parent_id,omitempty"` // unset on top-level categories
+	Path     string             `bson:"path" json:"path"`
+	// ChildCount is kept in step with the children's parent_id so deletes can
+	// refuse a category with children in a single operation.
+	ChildCount int       `bson:"child_count" json:"-"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// AncestorIDs returns the ids above c, root first.
+func (c *Category) AncestorIDs() []primitive.ObjectID {
+	var out []primitive.ObjectID
+	for _, part := range strings.Split(strings.Trim(c.Path, "/"), "/") {
+		id, err := primitive.ObjectIDFromHex(part)
+		if err != nil || id == c.ID {
+			continue
+		}
+		out = append(out, id)
+	}
+	return out
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// OIDCState remembers an OIDC login between the redirect to the provider and
+// the callback. It is keyed by the hash of the state parameter.
+type OIDCState struct {
+	ID           string    `bson:"_id" json:"-"`
+	Provider     string    `bson:"provider" json:"provider"`
+	Nonce        string    `bson:"nonce" json:"-"`
+	CodeVerifier string    `bson:"code_verifier" json:"-"`
+	ExpiresAt    time.Time `bson:"expires_at" json:"expires_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TokenPurpose string
+
+const (
+	PurposePasswordReset TokenPurpose = "password_reset"
+	PurposeEmailVerify   TokenPurpose = "email_verify"
+	PurposeEmailChange   TokenPurpose = "email_change"
+)
+
+// OneTimeToken is a single-use, expiring token mailed to a user. Only its hash
+// is stored.
+type OneTimeToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Purpose   TokenPurpose       `bson:"purpose" json:"purpose"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	Email     string             `bson:"email,omitempty" json:"-"` // new address, for email changes
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -6,6 +6,71 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+type OrderStatus string
+
+const (
+	OrderPending    OrderStatus = "pending"
+	OrderPaid       OrderStatus = "paid"
+	OrderProcessing OrderStatus = "processing"
+	OrderShipped    OrderStatus = "shipped"
+	OrderDelivered  OrderStatus = "delivered"
+	OrderCancelled  OrderStatus = "cancelled"
+	OrderRefunded   OrderStatus = "refunded"
+)
+
+// orderTransitions lists the statuses each status may move to. Cancelled and
+// refunded are terminal.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPending:    {OrderPaid, OrderCancelled},
+	OrderPaid:       {OrderProcessing, OrderCancelled, OrderRefunded},
+	OrderProcessing: {OrderShipped, OrderCancelled, OrderRefunded},
+	OrderShipped:    {OrderDelivered},
+	OrderDelivered:  {OrderRefunded},
+	OrderCancelled:  {},
+	OrderRefunded:   {},
+}
+
+func (s OrderStatus) Valid() bool {
+	_, ok := orderTransitions[s]
+	return ok
+}
+
+// NextStatuses returns the statuses s may legally move to.
+func (s OrderStatus) NextStatuses() []OrderStatus {
+	return orderTransitions[s]
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, n := range orderTransitions[s] {
+		if n == next {
+			return true
+		}
+	}
+	return false
+}
+
+// RestocksOn reports whether moving from -> to puts the order's items back in
+// stock: cancellations always do, refunds only if the goods never shipped.
+func RestocksOn(from, to OrderStatus) bool {
+	switch to {
+	case OrderCancelled:
+		return true
+	case OrderRefunded:
+		return from == OrderPaid || from == OrderProcessing
+	}
+	return false
+}
+
+// StatusChange is one entry in an order's status timeline.
+type StatusChange struct {
+	From     OrderStatus        `bson:"from,omitempty" json:"from,omitempty"` // empty on the entry made when the order is placed
+	To       OrderStatus        `bson:"to" json:"to"`
+	ActorID  primitive.ObjectID `bson:"actor_id,omitempty" json:"actor_id,omitempty"`     // user who made the change
+	APIKeyID primitive.ObjectID `bson:"api_key_id,omitempty" json:"api_key_id,omitempty"` // or the API key, for integrations
+	At       time.Time          `bson:"at" json:"at"`
+	Note     string             `bson:"note,omitempty" json:"note,omitempty"`
+}
+
 type OrderItem struct {
 	ProductID primitive.ObjectID `bson:"product_id" json:"product_id"`
 	Quantity  int                `bson:"quantity" json:"quantity"`
@@ -13,11 +78,23 @@ type OrderItem struct {
 }
 
 type Order struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
-	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
-	Items     []OrderItem        `bson:"items" json:"items"`
-	Total     float64            `bson:"total" json:"total"`
-	Status    string             `bson:"status" json:"status"` // pending, paid, shipped, cancelled
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	UserID primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"` // unset on guest orders until claimed
+	Items  []OrderItem        `bson:"items" json:"items"`
+	Total  float64            `bson:"total" json:"total"`
+	Status OrderStatus        `bson:"status" json:"status"`
+
+	// guest checkout: the order is found again with a token mailed to
+	// GuestEmail, and joins the account that later verifies that address
+	GuestEmail      string     `bson:"guest_email,omitempty" json:"guest_email,omitempty"`
+	LookupTokenHash string     `bson:"lookup_token_hash,omitempty" json:"-"`
+	ClaimedAt       *time.Time `bson:"claimed_at,omitempty" json:"claimed_at,omitempty"`
+
+	// copies taken when the order was placed
+	ShippingAddress *Address `bson:"shipping_address,omitempty" json:"shipping_address,omitempty"`
+	BillingAddress  *Address `bson:"billing_address,omitempty" json:"billing_address,omitempty"`
+
+	History   []StatusChange `bson:"history,omitempty" json:"-"` // served by GET /orders/:id/history
+	CreatedAt time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time      `bson:"updated_at" json:"updated_at"`
 }
@@ -0,0 +1,64 @@
+package models
+
+import "testing"
+
+var allStatuses = []OrderStatus{
+	OrderPending, OrderPaid, OrderProcessing, OrderShipped,
+	OrderDelivered, OrderCancelled, OrderRefunded,
+}
+
+func TestOrderTransitions(t *testing.T) {
+	allowed := map[OrderStatus][]OrderStatus{
+		OrderPending:    {OrderPaid, OrderCancelled},
+		OrderPaid:       {OrderProcessing, OrderCancelled, OrderRefunded},
+		OrderProcessing: {OrderShipped, OrderCancelled, OrderRefunded},
+		OrderShipped:    {OrderDelivered},
+		OrderDelivered:  {OrderRefunded},
+	}
+	for _, from := range allStatuses {
+		for _, to := range allStatuses {
+			want := false
+			for _, s := range allowed[from] {
+				want = want || s == to
+			}
+			if got := from.CanTransitionTo(to); got != want {
+				t.Errorf("%s -> %s: got %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestOrderStatusValid(t *testing.T) {
+	for _, s := range allStatuses {
+		if !s.Valid() {
+			t.Errorf("%s should be valid", s)
+		}
+	}
+	for _, s := range []OrderStatus{"", "Pending", "lost"} {
+		if s.Valid() || s.CanTransitionTo(OrderPaid) {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
+
+func TestRestocksOn(t *testing.T) {
+	tests := []struct {
+		from, to OrderStatus
+		want     bool
+	}{
+		{OrderPending, OrderCancelled, true},
+		{OrderPaid, OrderCancelled, true},
+		{OrderProcessing, OrderCancelled, true},
+		{OrderPaid, OrderRefunded, true},
+		{OrderProcessing, OrderRefunded, true},
+		{OrderDelivered, OrderRefunded, false},
+		{OrderPending, OrderPaid, false},
+		{OrderProcessing, OrderShipped, false},
+		{OrderShipped, OrderDelivered, false},
+	}
+	for _, tt := range tests {
+		if got := RestocksOn(tt.from, tt.to); got != tt.want {
+			t.Errorf("%s -> %s: got %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
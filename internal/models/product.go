@@ -12,6 +12,20 @@ type Product struct {
 	Description string             `bson:"description" json:"description"`
 	Price       float64            `bson:"price" json:"price"`
 	Stock       int                `bson:"stock" json:"stock"`
+	CategoryID  primitive.ObjectID `bson:"category_id,omitempty" json:"category_id,omitempty"`
 	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
 }
+
+// ProductMatch is a product found by full-text search with its relevance.
+type ProductMatch struct {
+	Product `bson:",inline"`
+	Score   float64 `bson:"score" json:"score"`
+}
+
+// StockShortage reports an order line that cannot be fulfilled from current stock.
+type StockShortage struct {
+	ProductID primitive.ObjectID `json:"product_id"`
+	Requested int                `json:"requested"`
+	Available int                `json:"available"`
+}
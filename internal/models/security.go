@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginAttempt is one failed login, keyed by "email:<addr>" or "ip:<addr>".
+type LoginAttempt struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	Key       string             `bson:"key" json:"key"`
+	At        time.Time          `bson:"at" json:"at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// LoginLock blocks every login attempt for Key until Until.
+type LoginLock struct {
+	Key   string    `bson:"_id" json:"key"`
+	Until time.Time `bson:"until" json:"until"`
+}
+
+const (
+	EventLoginLockout  = "login_lockout"
+	EventAccountErased = "account_erased"
+)
+
+// SecurityEvent is an entry in the security log.
+type SecurityEvent struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	Type    string             `bson:"type" json:"type"`
+	Email   string             `bson:"email,omitempty" json:"email,omitempty"`
+	Key     string             `bson:"key,omitempty" json:"key,omitempty"` // the login attempt key a lockout applies to
+	IP      string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	Details string             `bson:"details,omitempty" json:"details,omitempty"`
+	At      time.Time          `bson:"at" json:"at"`
+}
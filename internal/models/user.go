@@ -1,11 +1,42 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+)
+
+// Identity links a user to an account at an OpenID Connect provider.
+type Identity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"-"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
 
 type User struct {
-	ID           string    `bson:"_id,omitempty" json:"id"`
-	Name         string    `bson:"name" json:"name"`
-	Email        string    `bson:"email" json:"email"`
-	PasswordHash string    `bson:"password" json:"-"`
-	CreatedAt    time.Time `bson:"createdAt" json:"createdAt"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name          string             `bson:"name" json:"name"`
+	Email         string             `bson:"email" json:"email"`
+	PasswordHash  string             `bson:"password" json:"-"`
+	Role          string             `bson:"role" json:"role"` // customer, admin
+	EmailVerified bool               `bson:"email_verified" json:"email_verified"`
+
+	Disabled              bool       `bson:"disabled,omitempty" json:"disabled"`
+	DisabledAt            *time.Time `bson:"disabled_at,omitempty" json:"disabled_at,omitempty"`
+	PasswordResetRequired bool       `bson:"password_reset_required,omitempty" json:"password_reset_required"` // set by admins, cleared on reset
+	ErasedAt              *time.Time `bson:"erased_at,omitempty" json:"erased_at,omitempty"`                   // personal data removed on request
+
+	TOTPEnabled       bool     `bson:"totp_enabled" json:"totp_enabled"`
+	TOTPSecret        string   `bson:"totp_secret,omitempty" json:"-"`
+	TOTPPendingSecret string   `bson:"totp_pending_secret,omitempty" json:"-"` // awaiting confirmation
+	TOTPLastStep      int64    `bson:"totp_last_step,omitempty" json:"-"`      // last accepted step, blocks replays
+	RecoveryCodes     []string `bson:"recovery_codes,omitempty" json:"-"`      // SHA-256 hashes
+
+	Identities []Identity `bson:"identities,omitempty" json:"identities,omitempty"` // linked social logins
+	CreatedAt  time.Time  `bson:"createdAt" json:"createdAt"`
 }
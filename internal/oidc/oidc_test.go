@@ -0,0 +1,275 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// fakeProvider is a local OpenID provider serving discovery, a JWKS and a
+// token endpoint that hands out whatever ID token the test set.
+type fakeProvider struct {
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PrivateKey
+	jwksFetches int
+	idToken     string
+	lastForm    map[string]string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	f := &fakeProvider{keys: map[string]*rsa.PrivateKey{"k1": newRSAKey(t)}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 f.srv.URL,
+			"authorization_endpoint": f.srv.URL + "/authorize",
+			"token_endpoint":         f.srv.URL + "/token",
+			"jwks_uri":               f.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.jwksFetches++
+		var keys []map[string]string
+		for kid, k := range f.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+		writeJSON(w, map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.lastForm = map[string]string{}
+		for k := range r.PostForm {
+			f.lastForm[k] = r.PostForm.Get(k)
+		}
+		writeJSON(w, map[string]string{"id_token": f.idToken})
+	})
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeProvider) provider() *Provider {
+	return NewProvider(Config{Name: "fake", Issuer: f.srv.URL, ClientID: "client-1", RedirectURL: "http://app.test/callback"}, f.srv.Client())
+}
+
+func (f *fakeProvider) fetches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.jwksFetches
+}
+
+func (f *fakeProvider) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            f.srv.URL,
+		"aud":            "client-1",
+		"sub":            "user-42",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+	}
+}
+
+// sign issues an RS256 token with the provider key kid.
+func (f *fakeProvider) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	f.mu.Lock()
+	key := f.keys[kid]
+	f.mu.Unlock()
+	return signWith(t, jwt.SigningMethodRS256, kid, claims, key)
+}
+
+func signWith(t *testing.T, m jwt.SigningMethod, kid string, claims jwt.MapClaims, key interface{}) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(m, claims)
+	tok.Header["kid"] = kid
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	f := newFakeProvider(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(k string, v interface{}) jwt.MapClaims {
+		c := f.claims("n-1")
+		c[k] = v
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr string
+	}{
+		{"good token", func() string { return f.sign(t, "k1", f.claims("n-1")) }, ""},
+		{"audience list", func() string { return f.sign(t, "k1", with("aud", []string{"other", "client-1"})) }, ""},
+		{"wrong audience", func() string { return f.sign(t, "k1", with("aud", "client-2")) }, "wrong audience"},
+		{"wrong issuer", func() string { return f.sign(t, "k1", with("iss", "https://evil.test")) }, "wrong issuer"},
+		{"nonce mismatch", func() string { return f.sign(t, "k1", with("nonce", "n-2")) }, "nonce mismatch"},
+		{"missing nonce", func() string { return f.sign(t, "k1", with("nonce", "")) }, "nonce mismatch"},
+		{"expired", func() string { return f.sign(t, "k1", with("exp", time.Now().Add(-time.Minute).Unix())) }, "expired"},
+		{"HS256 with the public key as secret", func() string {
+			pub := f.keys["k1"].PublicKey
+			return signWith(t, jwt.SigningMethodHS256, "k1", f.claims("n-1"), pub.N.Bytes())
+		}, "doesn't match key"},
+		{"ES256 under an RSA kid", func() string {
+			return signWith(t, jwt.SigningMethodES256, "k1", f.claims("n-1"), ecKey)
+		}, "doesn't match key"},
+		{"signed by another key", func() string {
+			return signWith(t, jwt.SigningMethodRS256, "k1", f.claims("n-1"), newRSAKey(t))
+		}, "verification error"},
+	}
+	p := f.provider()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := p.VerifyIDToken(context.Background(), tt.token(), "n-1")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("VerifyIDToken: %v", err)
+				}
+				if id.Subject != "user-42" || id.Email != "alice@example.com" || !id.EmailVerified || id.Name != "Alice" {
+					t.Fatalf("unexpected claims %+v", id)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRefetchesUnknownKid(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, f.sign(t, "k1", f.claims("n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.fetches(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+
+	// the provider rolls its key
+	f.mu.Lock()
+	f.keys["k2"] = newRSAKey(t)
+	f.mu.Unlock()
+	rolled := f.sign(t, "k2", f.claims("n"))
+
+	// within a minute of the last fetch an unknown kid is refused outright
+	if _, err := p.VerifyIDToken(ctx, rolled, "n"); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Fatalf("got %v, want unknown key", err)
+	}
+	if got := f.fetches(); got != 1 {
+		t.Fatalf("JWKS fetched %d times within the throttle, want 1", got)
+	}
+
+	p.mu.Lock()
+	p.keysTime = time.Now().Add(-2 * time.Minute)
+	p.mu.Unlock()
+	if _, err := p.VerifyIDToken(ctx, rolled, "n"); err != nil {
+		t.Fatalf("after refetch: %v", err)
+	}
+	if got := f.fetches(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", got)
+	}
+
+	// known kids are served from the cache
+	if _, err := p.VerifyIDToken(ctx, f.sign(t, "k1", f.claims("n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.fetches(); got != 2 {
+		t.Fatalf("JWKS fetched %d times for a cached kid, want 2", got)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	f := newFakeProvider(t)
+	f.idToken = "the-id-token"
+	p := f.provider()
+
+	raw, err := p.Exchange(context.Background(), "code-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw != "the-id-token" {
+		t.Fatalf("got %q", raw)
+	}
+	want := map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          "code-1",
+		"code_verifier": "verifier-1",
+		"client_id":     "client-1",
+		"redirect_uri":  "http://app.test/callback",
+	}
+	for k, v := range want {
+		if f.lastForm[k] != v {
+			t.Errorf("form %s = %q, want %q", k, f.lastForm[k], v)
+		}
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	f := newFakeProvider(t)
+	u, err := f.provider().AuthCodeURL(context.Background(), "st", "nc", "ch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{f.srv.URL + "/authorize?", "state=st", "nonce=nc", "code_challenge=ch", "code_challenge_method=S256", "client_id=client-1"} {
+		if !strings.Contains(u, part) {
+			t.Errorf("%s lacks %s", u, part)
+		}
+	}
+}
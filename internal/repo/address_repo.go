@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Default flags on a saved address.
+const (
+	DefaultShipping = "default_shipping"
+	DefaultBilling  = "default_billing"
+)
+
+type AddressRepo struct {
+	col *mongo.Collection
+}
+
+func NewAddressRepo(db *mongo.Database) *AddressRepo {
+	return &AddressRepo{
+		col: db.Collection("addresses"),
+	}
+}
+
+func (r *AddressRepo) Create(ctx context.Context, a *models.SavedAddress) error {
+	a.ID = primitive.NewObjectID()
+	a.CreatedAt = time.Now().UTC()
+	a.UpdatedAt = a.CreatedAt
+	_, err := r.col.InsertOne(ctx, a)
+	return err
+}
+
+func (r *AddressRepo) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.SavedAddress, error) {
+	cur, err := r.col.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.SavedAddress{}
+	for cur.Next(ctx) {
+		var a models.SavedAddress
+		if err := cur.Decode(&a); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, cur.Err()
+}
+
+func (r *AddressRepo) CountByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return r.col.CountDocuments(ctx, bson.M{"user_id": userID})
+}
+
+// Get returns the user's address with the given id, or nil if the user has no
+// such address.
+func (r *AddressRepo) Get(ctx context.Context, userID, id primitive.ObjectID) (*models.SavedAddress, error) {
+	var a models.SavedAddress
+	err := r.col.FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&a)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &a, err
+}
+
+// Default returns the user's default address for flag, or nil if none is set.
+func (r *AddressRepo) Default(ctx context.Context, userID primitive.ObjectID, flag string) (*models.SavedAddress, error) {
+	var a models.SavedAddress
+	err := r.col.FindOne(ctx, bson.M{"user_id": userID, flag: true}).Decode(&a)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &a, err
+}
+
+func (r *AddressRepo) Update(ctx context.Context, userID, id primitive.ObjectID, update bson.M) (*models.SavedAddress, error) {
+	update["updated_at"] = time.Now().UTC()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var a models.SavedAddress
+	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id, "user_id": userID}, bson.M{"$set": update}, opts).Decode(&a)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &a, err
+}
+
+// ClearDefault unsets flag on every address of the user except keep, so at
+// most one address is the default.
+func (r *AddressRepo) ClearDefault(ctx context.Context, userID, keep primitive.ObjectID, flag string) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"user_id": userID, "_id": bson.M{"$ne": keep}, flag: true},
+		bson.M{"$set": bson.M{flag: false}},
+	)
+	return err
+}
+
+func (r *AddressRepo) Delete(ctx context.Context, userID, id primitive.ObjectID) error {
+	res, err := r.col.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *AddressRepo) DeleteForUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+func (r *AddressRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	return err
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type APIKeyRepo struct {
+	col *mongo.Collection
+}
+
+func NewAPIKeyRepo(db *mongo.Database) *APIKeyRepo {
+	return &APIKeyRepo{
+		col: db.Collection("api_keys"),
+	}
+}
+
+func (r *APIKeyRepo) Create(ctx context.Context, k *models.APIKey) error {
+	k.ID = primitive.NewObjectID()
+	k.CreatedAt = time.Now().UTC()
+	_, err := r.col.InsertOne(ctx, k)
+	return err
+}
+
+func (r *APIKeyRepo) FindByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	var k models.APIKey
+	err := r.col.FindOne(ctx, bson.M{"key_hash": hash}).Decode(&k)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &k, err
+}
+
+func (r *APIKeyRepo) List(ctx context.Context) ([]models.APIKey, error) {
+	cur, err := r.col.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.APIKey{}
+	for cur.Next(ctx) {
+		var k models.APIKey
+		if err := cur.Decode(&k); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, cur.Err()
+}
+
+func (r *APIKeyRepo) Revoke(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Touch records that a key was used. To spare a write on every request it only
+// updates last_used_at once a minute.
+func (r *APIKeyRepo) Touch(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now().UTC()
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{"last_used_at": bson.M{"$exists": false}},
+			bson.M{"last_used_at": bson.M{"$lt": now.Add(-time.Minute)}},
+		}},
+		bson.M{"$set": bson.M{"last_used_at": now}},
+	)
+	return err
+}
+
+func (r *APIKeyRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"key_hash": 1}, Options: options.Index().SetUnique(true),
+	})
+	return err
+}
@@ -0,0 +1,244 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSlugExists = errors.New("slug already in use")
+
+// ErrParentNotFound is returned when the parent of a new or moved category
+// was deleted in the meantime.
+var ErrParentNotFound = errors.New("parent category not found")
+
+// ErrHasChildren is returned when deleting a category that has subcategories.
+var ErrHasChildren = errors.New("category has subcategories")
+
+type CategoryRepo struct {
+	col *mongo.Collection
+}
+
+func NewCategoryRepo(db *mongo.Database) *CategoryRepo {
+	return &CategoryRepo{
+		col: db.Collection("categories"),
+	}
+}
+
+// Create inserts c as a child of parent, or at the top level if parent is nil.
+func (r *CategoryRepo) Create(ctx context.Context, c *models.Category, parent *models.Category) error {
+	c.ID = primitive.NewObjectID()
+	c.ParentID, c.Path = primitive.NilObjectID, "/"+c.ID.Hex()+"/"
+	if parent != nil {
+		c.ParentID, c.Path = parent.ID, parent.Path+c.ID.Hex()+"/"
+	}
+	now := time.Now().UTC()
+	c.CreatedAt, c.UpdatedAt, c.ChildCount = now, now, 0
+	if parent != nil {
+		if err := r.adoptChild(ctx, parent.ID); err != nil {
+			return err
+		}
+	}
+	_, err := r.col.InsertOne(ctx, c)
+	if err != nil && parent != nil {
+		_ = r.releaseChild(ctx, parent.ID)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrSlugExists
+	}
+	return err
+}
+
+// adoptChild counts a new child against id before it is linked, so a
+// concurrent Delete either sees the child or has already removed the parent.
+func (r *CategoryRepo) adoptChild(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"child_count": 1}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrParentNotFound
+	}
+	return nil
+}
+
+func (r *CategoryRepo) releaseChild(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id, "child_count": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"child_count": -1}})
+	return err
+}
+
+func (r *CategoryRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Category, error) {
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+func (r *CategoryRepo) FindBySlug(ctx context.Context, slug string) (*models.Category, error) {
+	return r.findOne(ctx, bson.M{"slug": slug})
+}
+
+func (r *CategoryRepo) findOne(ctx context.Context, filter bson.M) (*models.Category, error) {
+	var c models.Category
+	err := r.col.FindOne(ctx, filter).Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &c, err
+}
+
+// List returns every category ordered by path, so each subtree is contiguous.
+func (r *CategoryRepo) List(ctx context.Context) ([]models.Category, error) {
+	return r.find(ctx, bson.M{}, options.Find().SetSort(bson.M{"path": 1}))
+}
+
+// Children returns the categories directly below id, by name.
+func (r *CategoryRepo) Children(ctx context.Context, id primitive.ObjectID) ([]models.Category, error) {
+	return r.find(ctx, bson.M{"parent_id": id}, options.Find().SetSort(bson.M{"name": 1}))
+}
+
+// Ancestors returns the categories above c, root first.
+func (r *CategoryRepo) Ancestors(ctx context.Context, c *models.Category) ([]models.Category, error) {
+	ids := c.AncestorIDs()
+	if len(ids) == 0 {
+		return []models.Category{}, nil
+	}
+	// ancestors' paths are prefixes of each other, so shorter sorts first
+	return r.find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetSort(bson.M{"path": 1}))
+}
+
+// SubtreeIDs returns the ids of c and every category below it.
+func (r *CategoryRepo) SubtreeIDs(ctx context.Context, c *models.Category) ([]primitive.ObjectID, error) {
+	cur, err := r.col.Find(ctx, subtree(c.Path), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var out []primitive.ObjectID
+	for cur.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		out = append(out, doc.ID)
+	}
+	return out, cur.Err()
+}
+
+// Update sets the given fields and returns the updated category, or nil if
+// there is none. Use Move to change the parent.
+func (r *CategoryRepo) Update(ctx context.Context, id primitive.ObjectID, update bson.M) (*models.Category, error) {
+	update["updated_at"] = time.Now().UTC()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var c models.Category
+	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": update}, opts).Decode(&c)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrSlugExists
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &c, err
+}
+
+// Move re-parents c and its whole subtree under parent, or to the top level
+// if parent is nil. The caller must make sure parent isn't inside the
+// subtree. The new parent counts the child first, so it can't be deleted
+// underneath the move; paths are then rewritten before the parent link, so an
+// interrupted move can be finished by repeating it.
+func (r *CategoryRepo) Move(ctx context.Context, c *models.Category, parent *models.Category) error {
+	now := time.Now().UTC()
+	newPath := "/" + c.ID.Hex() + "/"
+	link := bson.M{"$unset": bson.M{"parent_id": ""}, "$set": bson.M{"updated_at": now}}
+	if parent != nil {
+		newPath = parent.Path + c.ID.Hex() + "/"
+		link = bson.M{"$set": bson.M{"parent_id": parent.ID, "updated_at": now}}
+		if err := r.adoptChild(ctx, parent.ID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.col.UpdateMany(ctx, subtree(c.Path), bson.A{bson.M{"$set": bson.M{
+		"path": bson.M{"$concat": bson.A{newPath, bson.M{"$substrBytes": bson.A{"$path", len(c.Path), bson.M{"$strLenBytes": "$path"}}}}},
+	}}}); err != nil {
+		return err
+	}
+	if _, err := r.col.UpdateOne(ctx, bson.M{"_id": c.ID}, link); err != nil {
+		return err
+	}
+	if !c.ParentID.IsZero() {
+		if err := r.releaseChild(ctx, c.ParentID); err != nil {
+			return err
+		}
+	}
+	c.ParentID, c.Path, c.UpdatedAt = primitive.NilObjectID, newPath, now
+	if parent != nil {
+		c.ParentID = parent.ID
+	}
+	return nil
+}
+
+// Delete removes the category unless it has children, returning
+// ErrHasChildren if it does and mongo.ErrNoDocuments if there is none.
+// An interrupted Create or Move can leave child_count too high, which only
+// ever makes Delete refuse.
+func (r *CategoryRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	var c models.Category
+	err := r.col.FindOneAndDelete(ctx, bson.M{"_id": id, "child_count": bson.M{"$not": bson.M{"$gt": 0}}}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		n, cerr := r.col.CountDocuments(ctx, bson.M{"_id": id})
+		if cerr != nil {
+			return cerr
+		}
+		if n > 0 {
+			return ErrHasChildren
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !c.ParentID.IsZero() {
+		return r.releaseChild(ctx, c.ParentID)
+	}
+	return nil
+}
+
+func (r *CategoryRepo) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]models.Category, error) {
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Category{}
+	for cur.Next(ctx) {
+		var c models.Category
+		if err := cur.Decode(&c); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, cur.Err()
+}
+
+// subtree matches a category and its descendants by path prefix, which the
+// path index can serve.
+func subtree(path string) bson.M {
+	return bson.M{"path": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(path)}}
+}
+
+func (r *CategoryRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "path", Value: 1}}},
+		{Keys: bson.D{{Key: "parent_id", Value: 1}, {Key: "name", Value: 1}}},
+	})
+	return err
+}
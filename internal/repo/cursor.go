@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidCursor is returned for cursors that weren't issued by a list with
+// the same sort order, or were tampered with.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page is one slice of a keyset-paginated list.
+type Page[T any] struct {
+	Items      []T
+	NextCursor string // "" on the last page
+	HasMore    bool
+}
+
+// cursor is the position after the last item of a page: that item's sort key
+// and _id. It is handed to clients as opaque base64 BSON.
+type cursor struct {
+	Sort string             `bson:"s"`
+	Key  interface{}        `bson:"k"`
+	ID   primitive.ObjectID `bson:"i"`
+}
+
+func encodeCursor(sort string, key interface{}, id primitive.ObjectID) string {
+	b, err := bson.Marshal(cursor{Sort: sort, Key: key, ID: id})
+	if err != nil {
+		return "" // keys are plain scalars, so this doesn't happen
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses s, which must have been issued for sort. Only scalar
+// keys are accepted so a crafted cursor can't smuggle query operators in.
+func decodeCursor(s, sort string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := bson.Unmarshal(b, &c); err != nil || c.Sort != sort || c.ID.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	switch c.Key.(type) {
+	case string, float64, int32, int64, primitive.DateTime:
+	default:
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// after selects the documents that follow c when sorting by field in
+// direction dir, with ties broken by _id in the same direction.
+func (c *cursor) after(field string, dir int) bson.M {
+	op := "$gt"
+	if dir < 0 {
+		op = "$lt"
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{op: c.Key}},
+		bson.M{field: c.Key, "_id": bson.M{op: c.ID}},
+	}}
+}
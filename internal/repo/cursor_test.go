@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	keys := []interface{}{
+		"widget",
+		19.99,
+		int32(7),
+		int64(1) << 40,
+		primitive.NewDateTimeFromTime(time.Unix(1700000000, 0)),
+	}
+	for _, key := range keys {
+		c, err := decodeCursor(encodeCursor("price", key, id), "price")
+		if err != nil {
+			t.Fatalf("%T: %v", key, err)
+		}
+		if c.Sort != "price" || c.ID != id || !reflect.DeepEqual(c.Key, key) {
+			t.Errorf("%T: got %+v", key, c)
+		}
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	id := primitive.NewObjectID()
+	raw := func(v interface{}) string {
+		b, err := bson.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	good := encodeCursor("price", 10.0, id)
+
+	tests := []struct {
+		name string
+		s    string
+	}{
+		{"issued for another sort", encodeCursor("-price", 10.0, id)},
+		{"operator document as key", raw(bson.M{"s": "price", "k": bson.M{"$ne": nil}, "i": id})},
+		{"array key", raw(bson.M{"s": "price", "k": bson.A{1, 2}, "i": id})},
+		{"missing key", raw(bson.M{"s": "price", "i": id})},
+		{"missing id", raw(bson.M{"s": "price", "k": 10.0})},
+		{"truncated", good[:len(good)/2]},
+		{"not base64", "!!not-a-cursor!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("xx"))},
+		{"empty document", raw(bson.M{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.s, "price"); !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("got %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestCursorAfter(t *testing.T) {
+	id := primitive.NewObjectID()
+	c := &cursor{Sort: "price", Key: 10.0, ID: id}
+	tests := []struct {
+		dir int
+		op  string
+	}{
+		{1, "$gt"},
+		{-1, "$lt"},
+	}
+	for _, tt := range tests {
+		want := bson.M{"$or": bson.A{
+			bson.M{"price": bson.M{tt.op: 10.0}},
+			bson.M{"price": 10.0, "_id": bson.M{tt.op: id}},
+		}}
+		if got := c.after("price", tt.dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("dir %d: got %v, want %v", tt.dir, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginAttemptRepo tracks failed logins and the lockouts they trigger.
+type LoginAttemptRepo struct {
+	attempts *mongo.Collection
+	locks    *mongo.Collection
+}
+
+func NewLoginAttemptRepo(db *mongo.Database) *LoginAttemptRepo {
+	return &LoginAttemptRepo{
+		attempts: db.Collection("login_attempts"),
+		locks:    db.Collection("login_locks"),
+	}
+}
+
+// RecordFailure stores a failed attempt that is kept for keep.
+func (r *LoginAttemptRepo) RecordFailure(ctx context.Context, key string, keep time.Duration) error {
+	now := time.Now().UTC()
+	_, err := r.attempts.InsertOne(ctx, models.LoginAttempt{
+		ID:        primitive.NewObjectID(),
+		Key:       key,
+		At:        now,
+		ExpiresAt: now.Add(keep),
+	})
+	return err
+}
+
+// Failures returns how many failed attempts key made since the given time and
+// when the latest one happened.
+func (r *LoginAttemptRepo) Failures(ctx context.Context, key string, since time.Time) (int64, time.Time, error) {
+	filter := bson.M{"key": key, "at": bson.M{"$gte": since}}
+	n, err := r.attempts.CountDocuments(ctx, filter)
+	if err != nil || n == 0 {
+		return n, time.Time{}, err
+	}
+	var last models.LoginAttempt
+	opts := options.FindOne().SetSort(bson.M{"at": -1})
+	if err := r.attempts.FindOne(ctx, filter, opts).Decode(&last); err != nil {
+		return 0, time.Time{}, err
+	}
+	return n, last.At, nil
+}
+
+func (r *LoginAttemptRepo) ClearFailures(ctx context.Context, key string) error {
+	_, err := r.attempts.DeleteMany(ctx, bson.M{"key": key})
+	return err
+}
+
+// Forget drops the failures and any lock recorded for key.
+func (r *LoginAttemptRepo) Forget(ctx context.Context, key string) error {
+	if err := r.ClearFailures(ctx, key); err != nil {
+		return err
+	}
+	_, err := r.locks.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+func (r *LoginAttemptRepo) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := r.locks.UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.M{"$set": bson.M{"until": until.UTC()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// LockedUntil returns when the lock on key ends, or the zero time if key isn't locked.
+func (r *LoginAttemptRepo) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var l models.LoginLock
+	err := r.locks.FindOne(ctx, bson.M{"_id": key, "until": bson.M{"$gt": time.Now().UTC()}}).Decode(&l)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	return l.Until, err
+}
+
+func (r *LoginAttemptRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.attempts.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.locks.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"until": 1}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
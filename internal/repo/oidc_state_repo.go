@@ -0,0 +1,43 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OIDCStateRepo struct {
+	col *mongo.Collection
+}
+
+func NewOIDCStateRepo(db *mongo.Database) *OIDCStateRepo {
+	return &OIDCStateRepo{
+		col: db.Collection("oidc_states"),
+	}
+}
+
+func (r *OIDCStateRepo) Create(ctx context.Context, s *models.OIDCState) error {
+	_, err := r.col.InsertOne(ctx, s)
+	return err
+}
+
+// Consume deletes and returns an unexpired state, or nil, nil if there is none.
+func (r *OIDCStateRepo) Consume(ctx context.Context, id string) (*models.OIDCState, error) {
+	var s models.OIDCState
+	err := r.col.FindOneAndDelete(ctx, bson.M{"_id": id, "expires_at": bson.M{"$gt": time.Now().UTC()}}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &s, err
+}
+
+func (r *OIDCStateRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OneTimeTokenRepo struct {
+	col *mongo.Collection
+}
+
+func NewOneTimeTokenRepo(db *mongo.Database) *OneTimeTokenRepo {
+	return &OneTimeTokenRepo{
+		col: db.Collection("one_time_tokens"),
+	}
+}
+
+func (r *OneTimeTokenRepo) Create(ctx context.Context, t *models.OneTimeToken) error {
+	t.ID = primitive.NewObjectID()
+	t.CreatedAt = time.Now().UTC()
+	_, err := r.col.InsertOne(ctx, t)
+	return err
+}
+
+// Consume marks a live token with the given hash and purpose as used and
+// returns it. It returns nil, nil if the token is unknown, expired or spent.
+func (r *OneTimeTokenRepo) Consume(ctx context.Context, hash string, purpose models.TokenPurpose) (*models.OneTimeToken, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"token_hash": hash,
+		"purpose":    purpose,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	}
+	var t models.OneTimeToken
+	err := r.col.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"used_at": now}}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &t, err
+}
+
+// InvalidateForUser spends every outstanding token of a purpose, so only the
+// most recently mailed one works.
+func (r *OneTimeTokenRepo) InvalidateForUser(ctx context.Context, userID primitive.ObjectID, purpose models.TokenPurpose) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"user_id": userID, "purpose": purpose, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+func (r *OneTimeTokenRepo) DeleteForUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+func (r *OneTimeTokenRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"token_hash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
@@ -26,12 +26,30 @@ func (r *OrderRepo) Create(ctx context.Context, o *models.Order) error {
 	now := time.Now().UTC()
 	o.CreatedAt, o.UpdatedAt = now, now
 	if o.Status == "" {
-		o.Status = "pending"
+		o.Status = models.OrderPending
+	}
+	for i := range o.History {
+		if o.History[i].At.IsZero() {
+			o.History[i].At = now
+		}
 	}
 	_, err := r.col.InsertOne(ctx, o)
 	return err
 }
 
+// ClaimGuestOrders attaches the guest orders placed with email to the user.
+// email must already be normalised the way guest orders store it.
+func (r *OrderRepo) ClaimGuestOrders(ctx context.Context, email string, userId primitive.ObjectID) (int64, error) {
+	res, err := r.col.UpdateMany(ctx,
+		bson.M{"guest_email": email, "user_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"user_id": userId, "claimed_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
 func (r *OrderRepo) GetById(ctx context.Context, id primitive.ObjectID) (*models.Order, error) {
 	var o models.Order
 	err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&o)
@@ -41,26 +59,64 @@ func (r *OrderRepo) GetById(ctx context.Context, id primitive.ObjectID) (*models
 	return &o, err
 }
 
-func (r *OrderRepo) ListByUser(ctx context.Context, userId primitive.ObjectID, page, limit int) ([]models.Order, error) {
-	if page < 1 {
-		page = 1
-	}
+// ListByUser returns up to limit of a user's orders, newest first, starting
+// after the cursor from a previous page ("" for the first page).
+func (r *OrderRepo) ListByUser(ctx context.Context, userId primitive.ObjectID, after string, limit int) (*Page[models.Order], error) {
 	if limit < 1 || limit > 100 {
 		limit = 20
 	}
-	skip := int64((page - 1) * limit)
+	const sort = "-created_at"
 
-	cur, err := r.col.Find(ctx, bson.M{"user_id": userId}, &options.FindOptions{
-		Skip:  &skip,
-		Limit: func(i int64) *int64 { return &i }(int64(limit)),
-		Sort:  bson.M{"created_at": -1},
-	})
+	filter := bson.M{"user_id": userId}
+	if after != "" {
+		c, err := decodeCursor(after, sort)
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{"$and": bson.A{filter, c.after("created_at", -1)}}
+	}
+
+	// one extra tells us whether there is another page
+	cur, err := r.col.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit+1)))
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(ctx)
 
-	var out []models.Order
+	page := &Page[models.Order]{Items: []models.Order{}}
+	for cur.Next(ctx) {
+		var o models.Order
+		if err := cur.Decode(&o); err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, o)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Items) > limit {
+		page.Items, page.HasMore = page.Items[:limit], true
+		last := &page.Items[limit-1]
+		page.NextCursor = encodeCursor(sort, primitive.NewDateTimeFromTime(last.CreatedAt), last.ID)
+	}
+	return page, nil
+}
+
+func (r *OrderRepo) CountByUser(ctx context.Context, userId primitive.ObjectID) (int64, error) {
+	return r.col.CountDocuments(ctx, bson.M{"user_id": userId})
+}
+
+// AllByUser returns every order of a user, oldest first, e.g. for a data export.
+func (r *OrderRepo) AllByUser(ctx context.Context, userId primitive.ObjectID) ([]models.Order, error) {
+	cur, err := r.col.Find(ctx, bson.M{"user_id": userId}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Order{}
 	for cur.Next(ctx) {
 		var o models.Order
 		if err := cur.Decode(&o); err != nil {
@@ -71,11 +127,43 @@ func (r *OrderRepo) ListByUser(ctx context.Context, userId primitive.ObjectID, p
 	return out, cur.Err()
 }
 
-func (r *OrderRepo) UpdateStatus(ctx context.Context, id primitive.ObjectID, status string) (*models.Order, error) {
-	update := bson.M{"status": status, "updated_at": time.Now().UTC()}
+// ScrubAddresses reduces the address copies on a user's orders to their
+// country, which tax reporting still needs, and drops the guest email and
+// lookup token from orders the user claimed.
+func (r *OrderRepo) ScrubAddresses(ctx context.Context, userId primitive.ObjectID) error {
+	if _, err := r.col.UpdateMany(ctx,
+		bson.M{"user_id": userId, "guest_email": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"guest_email": "", "lookup_token_hash": ""}},
+	); err != nil {
+		return err
+	}
+	for _, field := range []string{"shipping_address", "billing_address"} {
+		_, err := r.col.UpdateMany(ctx,
+			bson.M{"user_id": userId, field: bson.M{"$type": "object"}},
+			bson.A{bson.M{"$set": bson.M{field: bson.M{"country": "$" + field + ".country"}}}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateStatus moves an order from change.From to change.To and appends change
+// to its history. The update only applies while the order is still in
+// change.From, so it returns nil, nil if the order is missing or was changed
+// concurrently.
+func (r *OrderRepo) UpdateStatus(ctx context.Context, id primitive.ObjectID, change models.StatusChange) (*models.Order, error) {
+	if change.At.IsZero() {
+		change.At = time.Now().UTC()
+	}
+	update := bson.M{
+		"$set":  bson.M{"status": change.To, "updated_at": change.At},
+		"$push": bson.M{"history": change},
+	}
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 	var o models.Order
-	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": update}, opts).Decode(&o)
+	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id, "status": change.From}, update, opts).Decode(&o)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
@@ -92,3 +180,11 @@ func (r *OrderRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
 	}
 	return nil
 }
+
+func (r *OrderRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "guest_email", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	return err
+}
@@ -2,6 +2,7 @@ package repo
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
@@ -38,47 +39,206 @@ func (r *ProductRepo) GetById(ctx context.Context, id primitive.ObjectID) (*mode
 	return &p, err
 }
 
-func (r *ProductRepo) List(ctx context.Context, page, limit int) ([]models.Product, error) {
-	if page < 1 {
-		page = 1
+// ProductFilter narrows and orders ProductRepo.List. Zero fields don't
+// filter.
+type ProductFilter struct {
+	MinPrice     *float64
+	MaxPrice     *float64
+	InStock      bool
+	CategoryIDs  []primitive.ObjectID // any of these, e.g. a category's subtree
+	CreatedAfter time.Time
+	Sort         string // a key of productSorts; newest first otherwise
+}
+
+type sortKey struct {
+	field string
+	dir   int
+}
+
+// productSorts are the orders List accepts; "-" means descending. Ties are
+// broken by _id in the same direction.
+var productSorts = map[string]sortKey{
+	"price":       {"price", 1},
+	"-price":      {"price", -1},
+	"name":        {"name", 1},
+	"-name":       {"name", -1},
+	"created_at":  {"created_at", 1},
+	"-created_at": {"created_at", -1},
+}
+
+func (f ProductFilter) query() bson.M {
+	q := bson.M{}
+	price := bson.M{}
+	if f.MinPrice != nil {
+		price["$gte"] = *f.MinPrice
+	}
+	if f.MaxPrice != nil {
+		price["$lte"] = *f.MaxPrice
+	}
+	if len(price) > 0 {
+		q["price"] = price
+	}
+	if f.InStock {
+		q["stock"] = bson.M{"$gt": 0}
 	}
+	if f.CategoryIDs != nil {
+		q["category_id"] = bson.M{"$in": f.CategoryIDs}
+	}
+	if !f.CreatedAfter.IsZero() {
+		q["created_at"] = bson.M{"$gt": f.CreatedAfter}
+	}
+	return q
+}
+
+// sortKey returns the requested order and its name, falling back to newest
+// first.
+func (f ProductFilter) sortKey() (string, sortKey) {
+	if k, ok := productSorts[f.Sort]; ok {
+		return f.Sort, k
+	}
+	return "-created_at", productSorts["-created_at"]
+}
+
+// sortValue is p's value for the field named by k, for building cursors.
+func (k sortKey) sortValue(p *models.Product) interface{} {
+	switch k.field {
+	case "price":
+		return p.Price
+	case "name":
+		return p.Name
+	}
+	return primitive.NewDateTimeFromTime(p.CreatedAt)
+}
+
+// List returns up to limit products matching f, starting after the cursor
+// from a previous page ("" for the first page).
+func (r *ProductRepo) List(ctx context.Context, f ProductFilter, after string, limit int) (*Page[models.Product], error) {
 	if limit < 1 || limit > 100 {
 		limit = 20
 	}
-	skip := int64((page - 1) * limit)
+	name, k := f.sortKey()
+
+	filter := f.query()
+	if after != "" {
+		c, err := decodeCursor(after, name)
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{"$and": bson.A{filter, c.after(k.field, k.dir)}}
+	}
 
-	cur, err := r.col.Find(ctx, bson.M{}, &options.FindOptions{
-		Skip:  &skip,
-		Limit: func(i int64) *int64 { return &i }(int64(limit)),
-		Sort:  bson.M{"created_at": -1},
-	})
+	// one extra tells us whether there is another page
+	cur, err := r.col.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: k.field, Value: k.dir}, {Key: "_id", Value: k.dir}}).
+		SetLimit(int64(limit+1)))
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(ctx)
 
-	var out []models.Product
+	page := &Page[models.Product]{Items: []models.Product{}}
 	for cur.Next(ctx) {
 		var p models.Product
 		if err := cur.Decode(&p); err != nil {
 			return nil, err
 		}
-		out = append(out, p)
+		page.Items = append(page.Items, p)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Items) > limit {
+		page.Items, page.HasMore = page.Items[:limit], true
+		last := &page.Items[limit-1]
+		page.NextCursor = encodeCursor(name, k.sortValue(last), last.ID)
+	}
+	return page, nil
+}
+
+// Count returns how many products match f.
+func (r *ProductRepo) Count(ctx context.Context, f ProductFilter) (int64, error) {
+	return r.col.CountDocuments(ctx, f.query())
+}
+
+// MaxSearchPage bounds how deep Search pages, since each page skips over all
+// the matches before it.
+const MaxSearchPage = 500
+
+// Search runs a full-text query over name and description, best matches
+// first, and also returns the total number of matches. page is clamped to
+// 1..MaxSearchPage.
+func (r *ProductRepo) Search(ctx context.Context, q string, page, limit int) ([]models.ProductMatch, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if page > MaxSearchPage {
+		page = MaxSearchPage
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	skip := int64((page - 1) * limit)
+
+	filter := bson.M{"$text": bson.M{"$search": q}}
+	total, err := r.col.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	score := bson.M{"$meta": "textScore"}
+	cur, err := r.col.Find(ctx, filter, options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}).
+		SetSkip(skip).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.ProductMatch{}
+	for cur.Next(ctx) {
+		var m models.ProductMatch
+		if err := cur.Decode(&m); err != nil {
+			return nil, 0, err
+		}
+		out = append(out, m)
 	}
-	return out, cur.Err()
+	return out, total, cur.Err()
 }
 
+// Update sets the given fields, removing those whose value is nil, and
+// returns the updated product, or nil if there is none.
 func (r *ProductRepo) Update(ctx context.Context, id primitive.ObjectID, update bson.M) (*models.Product, error) {
-	update["updated_at"] = time.Now().UTC()
+	set, unset := bson.M{"updated_at": time.Now().UTC()}, bson.M{}
+	for k, v := range update {
+		if v == nil {
+			unset[k] = ""
+		} else {
+			set[k] = v
+		}
+	}
+	doc := bson.M{"$set": set}
+	if len(unset) > 0 {
+		doc["$unset"] = unset
+	}
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 	var p models.Product
-	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": update}, opts).Decode(&p)
+	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id}, doc, opts).Decode(&p)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
 	return &p, err
 }
 
+// ClearCategory takes every product out of the category id.
+func (r *ProductRepo) ClearCategory(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"category_id": id},
+		bson.M{"$unset": bson.M{"category_id": ""}, "$set": bson.M{"updated_at": time.Now().UTC()}},
+	)
+	return err
+}
+
 func (r *ProductRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
 	res, err := r.col.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
@@ -89,3 +249,82 @@ func (r *ProductRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
 	}
 	return nil
 }
+
+// DecrementStock takes qty units from a product only if that many are in stock.
+// It reports false, without modifying anything, when stock is insufficient.
+func (r *ProductRepo) DecrementStock(ctx context.Context, id primitive.ObjectID, qty int) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "stock": bson.M{"$gte": qty}},
+		bson.M{"$inc": bson.M{"stock": -qty}, "$set": bson.M{"updated_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *ProductRepo) IncrementStock(ctx context.Context, id primitive.ObjectID, qty int) error {
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"stock": qty}, "$set": bson.M{"updated_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// ReserveStock decrements stock for every item or for none of them. If a line
+// can't be satisfied the lines already taken are put back and the shortage is
+// returned; err is only set for database failures.
+func (r *ProductRepo) ReserveStock(ctx context.Context, items []models.OrderItem) ([]models.StockShortage, error) {
+	for i, it := range items {
+		ok, err := r.DecrementStock(ctx, it.ProductID, it.Quantity)
+		if err == nil && ok {
+			continue
+		}
+		if rbErr := r.ReleaseStock(ctx, items[:i]); rbErr != nil {
+			return nil, fmt.Errorf("rollback stock reservation: %w", rbErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		available := 0
+		if p, err := r.GetById(ctx, it.ProductID); err == nil && p != nil {
+			available = p.Stock
+		}
+		return []models.StockShortage{{ProductID: it.ProductID, Requested: it.Quantity, Available: available}}, nil
+	}
+	return nil, nil
+}
+
+// ReleaseStock returns previously reserved units. It deliberately ignores
+// cancellation of ctx so a rollback isn't abandoned halfway.
+func (r *ProductRepo) ReleaseStock(ctx context.Context, items []models.OrderItem) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	for _, it := range items {
+		if err := r.IncrementStock(ctx, it.ProductID, it.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ProductRepo) EnsureIndexes(ctx context.Context) error {
+	idx := []mongo.IndexModel{{
+		Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+		// a hit in the name counts for five in the description
+		Options: options.Index().
+			SetName("product_text").
+			SetWeights(bson.D{{Key: "name", Value: 10}, {Key: "description", Value: 2}}).
+			SetDefaultLanguage("english"),
+	}}
+	// one index per list order, alone and within a category; price and
+	// stock filters are applied to what these return
+	for _, field := range []string{"created_at", "price", "name"} {
+		idx = append(idx,
+			mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}, {Key: "_id", Value: 1}}},
+			mongo.IndexModel{Keys: bson.D{{Key: "category_id", Value: 1}, {Key: field, Value: 1}, {Key: "_id", Value: 1}}},
+		)
+	}
+	_, err := r.col.Indexes().CreateMany(ctx, idx)
+	return err
+}
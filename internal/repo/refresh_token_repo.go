@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RefreshTokenRepo struct {
+	col *mongo.Collection
+}
+
+func NewRefreshTokenRepo(db *mongo.Database) *RefreshTokenRepo {
+	return &RefreshTokenRepo{
+		col: db.Collection("refresh_tokens"),
+	}
+}
+
+func (r *RefreshTokenRepo) Create(ctx context.Context, t *models.RefreshToken) error {
+	t.ID = primitive.NewObjectID()
+	t.CreatedAt = time.Now().UTC()
+	_, err := r.col.InsertOne(ctx, t)
+	return err
+}
+
+func (r *RefreshTokenRepo) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var t models.RefreshToken
+	err := r.col.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &t, err
+}
+
+// MarkUsed flags a token as rotated. It reports false if the token was already
+// used or revoked, which callers must treat as reuse.
+func (r *RefreshTokenRepo) MarkUsed(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "used_at": bson.M{"$exists": false}, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *RefreshTokenRepo) RevokeFamily(ctx context.Context, familyID primitive.ObjectID) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"family_id": familyID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+func (r *RefreshTokenRepo) RevokeAllForUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// RevokeAllForUserExcept revokes every session but keep, e.g. the one that
+// just changed the password.
+func (r *RefreshTokenRepo) RevokeAllForUserExcept(ctx context.Context, userID, keep primitive.ObjectID) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"user_id": userID, "family_id": bson.M{"$ne": keep}, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+func (r *RefreshTokenRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"token_hash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"family_id": 1}},
+		// Mongo drops tokens once they expire
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SecurityEventRepo struct {
+	col *mongo.Collection
+}
+
+func NewSecurityEventRepo(db *mongo.Database) *SecurityEventRepo {
+	return &SecurityEventRepo{
+		col: db.Collection("security_events"),
+	}
+}
+
+func (r *SecurityEventRepo) Record(ctx context.Context, ev *models.SecurityEvent) error {
+	ev.ID = primitive.NewObjectID()
+	if ev.At.IsZero() {
+		ev.At = time.Now().UTC()
+	}
+	_, err := r.col.InsertOne(ctx, ev)
+	return err
+}
+
+// ScrubEmail removes email, and the IPs and lockout keys recorded with it,
+// from past events; the events themselves are kept. Emails are matched
+// ignoring case, as logins may have typed them either way.
+func (r *SecurityEventRepo) ScrubEmail(ctx context.Context, email string) error {
+	key := "email:" + strings.ToLower(strings.TrimSpace(email))
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"$or": bson.A{bson.M{"email": email}, bson.M{"key": key}}},
+		bson.M{"$unset": bson.M{"email": "", "key": "", "ip": ""}},
+		options.Update().SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+	)
+	return err
+}
+
+func (r *SecurityEventRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "at", Value: -1}}},
+	})
+	return err
+}
@@ -0,0 +1,132 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxUserAgent caps what we store from the User-Agent header.
+const maxUserAgent = 512
+
+type SessionRepo struct {
+	col *mongo.Collection
+}
+
+func NewSessionRepo(db *mongo.Database) *SessionRepo {
+	return &SessionRepo{
+		col: db.Collection("sessions"),
+	}
+}
+
+func (r *SessionRepo) Create(ctx context.Context, s *models.Session) error {
+	now := time.Now().UTC()
+	s.CreatedAt = now
+	s.LastSeenAt = now
+	s.UserAgent = clip(s.UserAgent, maxUserAgent)
+	_, err := r.col.InsertOne(ctx, s)
+	return err
+}
+
+func (r *SessionRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error) {
+	var s models.Session
+	err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &s, err
+}
+
+// ListActive returns the user's live sessions, most recently used first.
+func (r *SessionRepo) ListActive(ctx context.Context, userID primitive.ObjectID) ([]models.Session, error) {
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now().UTC()},
+	}
+	cur, err := r.col.Find(ctx, filter, options.Find().SetSort(bson.M{"last_seen_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Session{}
+	for cur.Next(ctx) {
+		var s models.Session
+		if err := cur.Decode(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, cur.Err()
+}
+
+// TouchInterval is how often requests record activity on a session.
+const TouchInterval = time.Minute
+
+// Touch records activity on a session. Requests only write once per
+// TouchInterval; refreshes pass extendTo to push the expiry back and always
+// write.
+func (r *SessionRepo) Touch(ctx context.Context, id primitive.ObjectID, ip, userAgent string, extendTo time.Time) error {
+	now := time.Now().UTC()
+	filter := bson.M{"_id": id}
+	set := bson.M{"last_seen_at": now, "ip": ip, "user_agent": clip(userAgent, maxUserAgent)}
+	if extendTo.IsZero() {
+		filter["$or"] = bson.A{
+			bson.M{"last_seen_at": bson.M{"$exists": false}},
+			bson.M{"last_seen_at": bson.M{"$lt": now.Add(-TouchInterval)}},
+		}
+	} else {
+		set["expires_at"] = extendTo.UTC()
+	}
+	_, err := r.col.UpdateOne(ctx, filter, bson.M{"$set": set})
+	return err
+}
+
+// Revoke ends one of the user's sessions. It reports false if there was no
+// such live session.
+func (r *SessionRepo) Revoke(ctx context.Context, userID, id primitive.ObjectID) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// RevokeAllForUser ends every session of the user except keep, which may be
+// primitive.NilObjectID.
+func (r *SessionRepo) RevokeAllForUser(ctx context.Context, userID, keep primitive.ObjectID) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"user_id": userID, "_id": bson.M{"$ne": keep}, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+func (r *SessionRepo) DeleteForUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+func (r *SessionRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}}},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func clip(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -3,14 +3,23 @@ package repo
 import (
 	"context"
 	"errors"
+	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var ErrEmailExists = errors.New("email already exists")
+
+// ErrLastAdmin is returned instead of demoting or disabling the only admin who
+// can still sign in.
+var ErrLastAdmin = errors.New("this is the last active admin")
+
 type UserRepo struct {
 	col *mongo.Collection
 }
@@ -20,27 +29,355 @@ func NewUserRepo(db *mongo.Database) *UserRepo {
 }
 
 func (r *UserRepo) Create(ctx context.Context, user *models.User) error {
+	user.ID = primitive.NewObjectID()
 	user.CreatedAt = time.Now().UTC()
 	_, err := r.col.InsertOne(ctx, user)
 	if mongo.IsDuplicateKeyError(err) {
-		return errors.New("email already exists")
+		return ErrEmailExists
 	}
 	return err
 }
 
+// emailCollation compares email addresses case-insensitively.
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// FindByEmail looks a user up by email address, ignoring case.
 func (r *UserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
 	var u models.User
-	err := r.col.FindOne(ctx, bson.M{"email": email}).Decode(&u)
+	err := r.col.FindOne(ctx, bson.M{"email": email}, options.FindOne().SetCollation(emailCollation)).Decode(&u)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
 	return &u, err
 }
 
-func (r *UserRepo) EnsureIndexes(ctx context.Context) error {
-	_, err := r.col.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.M{"email": 1},
-		Options: options.Index().SetUnique(true),
+func (r *UserRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	var u models.User
+	err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &u, err
+}
+
+// List returns a page of users, newest first, and the total number matching.
+// A non-empty search matches name or email case-insensitively.
+func (r *UserRepo) List(ctx context.Context, search string, page, limit int) ([]models.User, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	skip := int64((page - 1) * limit)
+
+	filter := bson.M{}
+	if search != "" {
+		re := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"name": re}, bson.M{"email": re}}
+	}
+
+	total, err := r.col.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cur, err := r.col.Find(ctx, filter, &options.FindOptions{
+		Skip:  &skip,
+		Limit: func(i int64) *int64 { return &i }(int64(limit)),
+		Sort:  bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.User{}
+	for cur.Next(ctx) {
+		var u models.User
+		if err := cur.Decode(&u); err != nil {
+			return nil, 0, err
+		}
+		out = append(out, u)
+	}
+	return out, total, cur.Err()
+}
+
+// Update sets the given fields and returns the updated user, or nil if there is
+// no such user.
+func (r *UserRepo) Update(ctx context.Context, id primitive.ObjectID, update bson.M) (*models.User, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var u models.User
+	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": update}, opts).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &u, err
+}
+
+// UpdateEmail switches the user to a confirmed new address.
+func (r *UserRepo) UpdateEmail(ctx context.Context, id primitive.ObjectID, email string) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"email": email, "email_verified": true}})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailExists
+	}
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// UpdatePassword also clears a reset demanded by an admin.
+func (r *UserRepo) UpdatePassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set":   bson.M{"password": passwordHash},
+		"$unset": bson.M{"password_reset_required": ""},
 	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// SetDisabled disables or re-enables the account. Disabling the last active
+// admin fails with ErrLastAdmin.
+func (r *UserRepo) SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	enable := bson.M{"$unset": bson.M{"disabled": "", "disabled_at": ""}}
+	if !disabled {
+		return r.updateOne(ctx, id, enable)
+	}
+	return r.leaveAdmins(ctx, id, bson.M{"$set": bson.M{"disabled": true, "disabled_at": time.Now().UTC()}}, enable)
+}
+
+// SetRole changes the user's role. Demoting the last active admin fails with
+// ErrLastAdmin.
+func (r *UserRepo) SetRole(ctx context.Context, id primitive.ObjectID, role string) error {
+	update := bson.M{"$set": bson.M{"role": role}}
+	if role == models.RoleAdmin {
+		return r.updateOne(ctx, id, update)
+	}
+	return r.leaveAdmins(ctx, id, update, bson.M{"$set": bson.M{"role": models.RoleAdmin}})
+}
+
+func activeAdmins() bson.M {
+	return bson.M{"role": models.RoleAdmin, "disabled": bson.M{"$ne": true}}
+}
+
+// leaveAdmins applies update, which takes an active admin out of the active
+// admins. If that leaves none, undo puts the user back and ErrLastAdmin is
+// returned. Counting after the write rather than before is what makes this
+// safe: of two admins demoting each other at once, whichever counts second
+// sees both writes, so at worst both are refused.
+func (r *UserRepo) leaveAdmins(ctx context.Context, id primitive.ObjectID, update, undo bson.M) error {
+	filter := activeAdmins()
+	filter["_id"] = id
+	res, err := r.col.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		// not an active admin, nothing to guard
+		return r.updateOne(ctx, id, update)
+	}
+	n, err := r.col.CountDocuments(ctx, activeAdmins())
+	if err == nil && n > 0 {
+		return nil
+	}
+	if _, undoErr := r.col.UpdateOne(ctx, bson.M{"_id": id}, undo); undoErr != nil {
+		return undoErr
+	}
+	if err != nil {
+		return err
+	}
+	return ErrLastAdmin
+}
+
+// RequirePasswordReset blocks logins with the current password until the user
+// sets a new one.
+func (r *UserRepo) RequirePasswordReset(ctx context.Context, id primitive.ObjectID) error {
+	return r.updateOne(ctx, id, bson.M{"$set": bson.M{"password_reset_required": true}})
+}
+
+// Anonymize strips personal data from the user and disables the account. The
+// document is kept so orders still point at something.
+func (r *UserRepo) Anonymize(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now().UTC()
+	return r.updateOne(ctx, id, bson.M{
+		"$set": bson.M{
+			"name":           "Deleted user",
+			"email":          "deleted-" + id.Hex() + "@erased.invalid", // email is unique
+			"email_verified": false,
+			"totp_enabled":   false,
+			"disabled":       true,
+			"disabled_at":    now,
+			"erased_at":      now,
+		},
+		"$unset": bson.M{
+			"password":                "",
+			"totp_secret":             "",
+			"totp_pending_secret":     "",
+			"totp_last_step":          "",
+			"recovery_codes":          "",
+			"identities":              "",
+			"password_reset_required": "",
+		},
+	})
+}
+
+func (r *UserRepo) updateOne(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *UserRepo) MarkEmailVerified(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"email_verified": true}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *UserRepo) SetPendingTOTP(ctx context.Context, id primitive.ObjectID, secret string) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"totp_pending_secret": secret}})
 	return err
 }
+
+// EnableTOTP promotes the pending secret and stores the recovery code hashes.
+// It reports false if there was no pending secret to promote.
+func (r *UserRepo) EnableTOTP(ctx context.Context, id primitive.ObjectID, secret string, step int64, recoveryHashes []string) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "totp_pending_secret": secret},
+		bson.M{
+			"$set": bson.M{
+				"totp_enabled":   true,
+				"totp_secret":    secret,
+				"totp_last_step": step,
+				"recovery_codes": recoveryHashes,
+			},
+			"$unset": bson.M{"totp_pending_secret": ""},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *UserRepo) DisableTOTP(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set":   bson.M{"totp_enabled": false},
+		"$unset": bson.M{"totp_secret": "", "totp_pending_secret": "", "totp_last_step": "", "recovery_codes": ""},
+	})
+	return err
+}
+
+// UseTOTPStep records step as used. It reports false if that step, or a later
+// one, was already used, i.e. the code is being replayed.
+func (r *UserRepo) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// UseRecoveryCode removes a recovery code hash, reporting false if it wasn't present.
+func (r *UserRepo) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *UserRepo) FindByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	var u models.User
+	err := r.col.FindOne(ctx, bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &u, err
+}
+
+// LinkIdentity attaches an OIDC identity to a user. Linking happens on a
+// verified email, so it also marks the email verified.
+func (r *UserRepo) LinkIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$push": bson.M{"identities": identity},
+		"$set":  bson.M{"email_verified": true},
+	})
+	return err
+}
+
+func (r *UserRepo) EnsureIndexes(ctx context.Context) error {
+	// email_ci used to be a plain lookup index; it can't be turned unique in
+	// place, so it is rebuilt
+	if err := r.dropEmailIndex(ctx, func(ix emailIndex) bool { return ix.Name == "email_ci" && !ix.Unique }); err != nil {
+		return err
+	}
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// unique regardless of case, and serves FindByEmail
+		{Keys: bson.M{"email": 1}, Options: options.Index().SetName("email_ci").SetUnique(true).SetCollation(emailCollation)},
+		{
+			Keys:    bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("users: some emails differ only in case; merge those accounts before starting: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+	// the case-sensitive unique index is only dropped once its replacement
+	// is in place
+	return r.dropEmailIndex(ctx, func(ix emailIndex) bool { return ix.Name == "email_1" && ix.Collation == nil })
+}
+
+type emailIndex struct {
+	Name      string `bson:"name"`
+	Unique    bool   `bson:"unique"`
+	Collation bson.M `bson:"collation"`
+}
+
+func (r *UserRepo) dropEmailIndex(ctx context.Context, stale func(emailIndex) bool) error {
+	cur, err := r.col.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var indexes []emailIndex
+	if err := cur.All(ctx, &indexes); err != nil {
+		return err
+	}
+	for _, ix := range indexes {
+		if stale(ix) {
+			if _, err := r.col.Indexes().DropOne(ctx, ix.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
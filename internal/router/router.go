@@ -1,17 +1,25 @@
 package router
 
 import (
+	"context"
+	"log"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/saurabhraut1212/ecommerce_backend/internal/config"
 	"github.com/saurabhraut1212/ecommerce_backend/internal/handlers"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/jwtkeys"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/mailer"
 	"github.com/saurabhraut1212/ecommerce_backend/internal/middleware"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/models"
+	"github.com/saurabhraut1212/ecommerce_backend/internal/oidc"
 	"github.com/saurabhraut1212/ecommerce_backend/internal/repo"
 
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func New(cfg *config.Config, client *mongo.Client) *fiber.App {
+func New(cfg *config.Config, client *mongo.Client, mail mailer.Mailer, keys *jwtkeys.KeySet) *fiber.App {
 	app := fiber.New()
 	app.Use(logger.New())
 
@@ -19,34 +27,150 @@ func New(cfg *config.Config, client *mongo.Client) *fiber.App {
 	userRepo := repo.NewUserRepo(client.Database(cfg.MongoDB))
 	productRepo := repo.NewProductRepo(client.Database(cfg.MongoDB))
 	orderRepo := repo.NewOrderRepo(client.Database(cfg.MongoDB))
+	refreshRepo := repo.NewRefreshTokenRepo(client.Database(cfg.MongoDB))
+	oneTimeRepo := repo.NewOneTimeTokenRepo(client.Database(cfg.MongoDB))
+	loginAttemptRepo := repo.NewLoginAttemptRepo(client.Database(cfg.MongoDB))
+	securityEventRepo := repo.NewSecurityEventRepo(client.Database(cfg.MongoDB))
+	oidcStateRepo := repo.NewOIDCStateRepo(client.Database(cfg.MongoDB))
+	apiKeyRepo := repo.NewAPIKeyRepo(client.Database(cfg.MongoDB))
+	sessionRepo := repo.NewSessionRepo(client.Database(cfg.MongoDB))
+	addressRepo := repo.NewAddressRepo(client.Database(cfg.MongoDB))
+	categoryRepo := repo.NewCategoryRepo(client.Database(cfg.MongoDB))
+
+	ensureIndexes(userRepo, refreshRepo, oneTimeRepo, loginAttemptRepo, securityEventRepo, oidcStateRepo, apiKeyRepo, sessionRepo, addressRepo, orderRepo, productRepo, categoryRepo)
 
 	//handlers
-	authH := handlers.NewAuthHandler(userRepo, cfg.JWTSecret)
-	productH := handlers.NewProductHandler(productRepo)
-	orderH := handlers.NewOrderHandler(productRepo, orderRepo)
+	loginGuard := handlers.NewLoginGuard(loginAttemptRepo, securityEventRepo, cfg)
+	authH := handlers.NewAuthHandler(userRepo, refreshRepo, oneTimeRepo, sessionRepo, orderRepo, loginGuard, keys, mail, cfg)
+	var providers []*oidc.Provider
+	for _, p := range cfg.OIDCProviders {
+		providers = append(providers, oidc.NewProvider(oidc.Config{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		}, nil))
+	}
+	oidcH := handlers.NewOIDCHandler(providers, oidcStateRepo, authH)
+	passwordH := handlers.NewPasswordHandler(userRepo, oneTimeRepo, refreshRepo, sessionRepo, mail, cfg)
+	productH := handlers.NewProductHandler(productRepo, categoryRepo)
+	orderH := handlers.NewOrderHandler(productRepo, orderRepo, userRepo, addressRepo, mail, cfg)
+	apiKeyH := handlers.NewAPIKeyHandler(apiKeyRepo)
+	adminUserH := handlers.NewAdminUserHandler(userRepo, refreshRepo, sessionRepo, passwordH)
+	addressH := handlers.NewAddressHandler(addressRepo)
+	categoryH := handlers.NewCategoryHandler(categoryRepo, productRepo)
+	privacyH := handlers.NewPrivacyHandler(userRepo, orderRepo, addressRepo, refreshRepo, sessionRepo, oneTimeRepo, loginAttemptRepo, securityEventRepo)
 
 	//Health
 	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("Server running") })
 	app.Get("/health", func(c *fiber.Ctx) error { return c.SendString("OK") })
 
+	//public signing keys for services verifying our tokens
+	app.Get("/.well-known/jwks.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(keys.JWKS())
+	})
+
+	authCfg := middleware.AuthConfig{Keys: keys, APIKeys: apiKeyRepo, Users: userRepo, Sessions: sessionRepo}
+	auth := middleware.RequireAuth(authCfg)
+	authOrKey := middleware.RequireAuthOrAPIKey(authCfg) // also accepts X-API-Key
+	adminOnly := middleware.RequireRole(models.RoleAdmin)
+	productsWrite := middleware.RequireScope(models.ScopeProductsWrite, models.RoleAdmin)
+	ordersRead := middleware.RequireScope(models.ScopeOrdersRead)
+	ordersWrite := middleware.RequireScope(models.ScopeOrdersWrite, models.RoleAdmin)
+
 	api := app.Group("/api")
 	//auth
 	api.Post("/register", authH.Register)
 	api.Post("/login", authH.Login)
+	api.Post("/login/2fa", authH.LoginMFA)
+	api.Get("/auth/oidc/:provider/login", oidcH.Login)
+	api.Get("/auth/oidc/:provider/callback", oidcH.Callback)
+	api.Post("/token/refresh", authH.Refresh)
+	api.Post("/logout", authH.Logout)
+	api.Get("/verify-email", authH.VerifyEmail) // ?token=...
+	api.Post("/verify-email/resend", auth, authH.ResendVerification)
+	api.Post("/password/forgot", passwordH.Forgot)
+	api.Post("/password/reset", passwordH.Reset)
 
 	//products
 	api.Get("/products", productH.List)
+	api.Get("/products/search", productH.Search) // ?q=...&page=1&limit=20
 	api.Get("/products/:id", productH.Get)
-	api.Post("/products", middleware.RequireAuth(), productH.Create)
-	api.Put("/products/:id", middleware.RequireAuth(), productH.Update)
-	api.Delete("/products/:id", middleware.RequireAuth(), productH.Delete)
+	api.Post("/products", authOrKey, productsWrite, productH.Create)
+	api.Put("/products/:id", authOrKey, productsWrite, productH.Update)
+	api.Delete("/products/:id", authOrKey, productsWrite, productH.Delete)
+
+	//categories
+	api.Get("/categories", categoryH.List)
+	api.Get("/categories/:slug", categoryH.Get)
+	api.Get("/categories/:slug/products", productH.ListInCategory) // same query as /products, minus category
 
 	//orders
-	api.Post("/orders", middleware.RequireAuth(), orderH.Create)
-	api.Get("/orders/:id", middleware.RequireAuth(), orderH.Get)
-	api.Get("/orders", middleware.RequireAuth(), orderH.ListByUser) // ?user_id=...&page=1&limit=20
-	api.Patch("/orders/:id/status", middleware.RequireAuth(), orderH.UpdateStatus)
-	api.Delete("/orders/:id", middleware.RequireAuth(), orderH.Delete)
+	api.Post("/orders", auth, orderH.Create)
+	api.Post("/orders/guest", orderH.CreateGuest)
+	api.Get("/orders/guest/:id", orderH.GetGuest) // ?token=... from the confirmation email
+	api.Get("/orders/:id", authOrKey, ordersRead, orderH.Get)
+	api.Get("/orders", authOrKey, ordersRead, orderH.ListByUser) // ?user_id=...&cursor=...&limit=20 (user_id: admins and API keys only)
+	api.Patch("/orders/:id/status", authOrKey, ordersWrite, orderH.UpdateStatus)
+	api.Get("/orders/:id/history", authOrKey, ordersRead, orderH.History)
+	api.Delete("/orders/:id", auth, orderH.Delete)
+	api.Get("/me/orders", auth, orderH.ListMine) // ?cursor=...&limit=20
+
+	//profile
+	api.Get("/me", auth, authH.Me)
+	api.Patch("/me", auth, authH.UpdateMe)
+	api.Post("/me/password", auth, authH.ChangePassword)
+	api.Post("/me/email", auth, authH.ChangeEmail)
+	api.Get("/me/email/confirm", authH.ConfirmEmailChange) // ?token=... from the email
+	api.Get("/me/sessions", auth, authH.ListSessions)
+	api.Delete("/me/sessions/:id", auth, authH.RevokeSession)
+	api.Get("/me/addresses", auth, addressH.List)
+	api.Post("/me/addresses", auth, addressH.Create)
+	api.Get("/me/addresses/:id", auth, addressH.Get)
+	api.Patch("/me/addresses/:id", auth, addressH.Update)
+	api.Delete("/me/addresses/:id", auth, addressH.Delete)
+	api.Get("/me/export", auth, privacyH.Export)
+	api.Post("/me/erase", auth, privacyH.EraseMe)
+
+	//two-factor
+	api.Post("/me/2fa/enroll", auth, authH.EnrollTOTP)
+	api.Post("/me/2fa/confirm", auth, authH.ConfirmTOTP)
+	api.Post("/me/2fa/disable", auth, authH.DisableTOTP)
+
+	//admin
+	api.Post("/admin/api-keys", auth, adminOnly, apiKeyH.Create)
+	api.Get("/admin/api-keys", auth, adminOnly, apiKeyH.List)
+	api.Delete("/admin/api-keys/:id", auth, adminOnly, apiKeyH.Revoke)
+	api.Post("/admin/categories", auth, adminOnly, categoryH.Create)
+	api.Patch("/admin/categories/:id", auth, adminOnly, categoryH.Update)
+	api.Delete("/admin/categories/:id", auth, adminOnly, categoryH.Delete)
+	api.Get("/admin/users", auth, adminOnly, adminUserH.List) // ?q=...&page=1&limit=20
+	api.Get("/admin/users/:id", auth, adminOnly, adminUserH.Get)
+	api.Get("/admin/users/:id/orders", auth, adminOnly, orderH.ListForUser)
+	api.Patch("/admin/users/:id/role", auth, adminOnly, adminUserH.SetRole)
+	api.Post("/admin/users/:id/disable", auth, adminOnly, adminUserH.Disable)
+	api.Post("/admin/users/:id/enable", auth, adminOnly, adminUserH.Enable)
+	api.Post("/admin/users/:id/password-reset", auth, adminOnly, adminUserH.ForcePasswordReset)
+	api.Post("/admin/users/:id/erase", auth, adminOnly, privacyH.EraseUser)
 
 	return app
 }
+
+type indexer interface {
+	EnsureIndexes(ctx context.Context) error
+}
+
+// ensureIndexes creates the indexes each repo relies on. Failures are logged
+// rather than fatal so a user without index privileges can still run the API.
+func ensureIndexes(repos ...indexer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	for _, r := range repos {
+		if err := r.EnsureIndexes(ctx); err != nil {
+			log.Printf("ensure indexes: %v", err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed from RFC 6238 appendix B, "12345678901234567890".
+var rfcSecret = b32.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeAtRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; with 6 digits the same truncated value is
+	// taken mod 10^6, so the expected codes are their last six digits.
+	tests := []struct {
+		unix int64
+		rfc  string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+		got, err := CodeAt(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.rfc[len(tt.rfc)-Digits:]; got != want {
+			t.Errorf("T=%d: got %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestCodeAtInvalidSecret(t *testing.T) {
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Fatal("expected an error for a malformed secret")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(s int64) string {
+		c, err := CodeAt(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		skew     int
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(step), 1, step, true},
+		{"previous step within skew", code(step - 1), 1, step - 1, true},
+		{"next step within skew", code(step + 1), 1, step + 1, true},
+		{"two steps back", code(step - 2), 1, 0, false},
+		{"two steps ahead", code(step + 2), 1, 0, false},
+		{"previous step without skew", code(step - 1), 0, 0, false},
+		{"spaces are ignored", code(step)[:3] + " " + code(step)[3:], 1, step, true},
+		{"too short", code(step)[:5], 1, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || got != tt.wantStep {
+				t.Fatalf("got (%d, %v), want (%d, %v)", got, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestValidateReplay mirrors how the login flow uses the returned step: a code
+// is only accepted if its step is later than the last one used, which is what
+// UserRepo.UseTOTPStep enforces in the database.
+func TestValidateReplay(t *testing.T) {
+	start := time.Unix(1234567890, 0)
+	current, err := CodeAt(rfcSecret, Step(start))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := CodeAt(rfcSecret, Step(start)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		code   string
+		at     time.Time
+		wantOK bool
+	}{
+		{"first use", current, start, true},
+		{"same code again", current, start, false},
+		{"same code in the next step", current, start.Add(Period), false},
+		{"older code still in the window", previous, start, false},
+		{"fresh code", "", start.Add(Period), true},
+	}
+	var last int64 = -1
+	for _, tt := range tests {
+		code := tt.code
+		if code == "" {
+			if code, err = CodeAt(rfcSecret, Step(tt.at)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ok := false
+		if step, valid := Validate(rfcSecret, code, tt.at, 1); valid && step > last {
+			last, ok = step, true
+		}
+		if ok != tt.wantOK {
+			t.Errorf("%s: accepted = %v, want %v", tt.name, ok, tt.wantOK)
+		}
+	}
+}
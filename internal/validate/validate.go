@@ -0,0 +1,149 @@
+// Package validate checks request DTOs against their `validate` struct tags and
+// reports failures per field, using the field's JSON name.
+//
+// Besides the stock go-playground rules (required, email, min, max, len, ...)
+// it provides:
+//   - positive: the number is greater than zero
+//   - valid: the value's Valid() method returns true
+//   - country: an ISO 3166-1 alpha-2 code, in either case
+//   - slug: lowercase letters and digits in hyphen-separated words
+//
+// Fields of embedded structs are reported without the struct's name, matching
+// how encoding/json flattens them.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+type validity interface {
+	Valid() bool
+}
+
+var slugRE = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// embedded stands in for the name of an embedded struct in namespaces until
+// Struct strips it.
+const embedded = "~"
+
+var v = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" && f.Anonymous {
+			return embedded
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+	v.RegisterAlias("positive", "gt=0")
+	_ = v.RegisterValidation("valid", func(fl validator.FieldLevel) bool {
+		if vv, ok := fl.Field().Interface().(validity); ok {
+			return vv.Valid()
+		}
+		return false
+	})
+	_ = v.RegisterValidation("country", func(fl validator.FieldLevel) bool {
+		return v.Var(strings.ToUpper(fl.Field().String()), "iso3166_1_alpha2") == nil
+	})
+	_ = v.RegisterValidation("slug", func(fl validator.FieldLevel) bool {
+		return slugRE.MatchString(fl.Field().String())
+	})
+	return v
+}
+
+// Struct validates s and returns one FieldError per failing field, or nil.
+func Struct(s interface{}) []FieldError {
+	err := v.Struct(s)
+	if err == nil {
+		return nil
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return []FieldError{{Field: "", Rule: "invalid", Message: err.Error()}}
+	}
+	// named structs prefix the namespace with their type name; anonymous
+	// request structs don't
+	prefix := ""
+	if t := reflect.Indirect(reflect.ValueOf(s)).Type(); t.Name() != "" {
+		prefix = t.Name() + "."
+	}
+	out := make([]FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		param := fe.Param()
+		if fe.Tag() != fe.ActualTag() {
+			param = "" // aliases such as positive carry no user-facing parameter
+		}
+		out = append(out, FieldError{
+			Field:   strings.ReplaceAll(strings.TrimPrefix(fe.Namespace(), prefix), embedded+".", ""),
+			Rule:    fe.Tag(),
+			Param:   param,
+			Message: message(fe),
+		})
+	}
+	return out
+}
+
+func message(fe validator.FieldError) string {
+	isString := fe.Kind() == reflect.String
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "positive":
+		return "must be greater than 0"
+	case "mongodb":
+		return "must be a valid id"
+	case "valid":
+		return "is not an accepted value"
+	case "country":
+		return "must be a two-letter ISO country code"
+	case "slug":
+		return "must be lowercase letters and digits separated by hyphens"
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "len":
+		if isString {
+			return fmt.Sprintf("must be exactly %s characters", fe.Param())
+		}
+		return fmt.Sprintf("must contain exactly %s items", fe.Param())
+	case "min", "gte":
+		if isString {
+			return fmt.Sprintf("must be at least %s characters", fe.Param())
+		}
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must contain at least %s items", fe.Param())
+		}
+		return "must be at least " + fe.Param()
+	case "max", "lte":
+		if isString {
+			return fmt.Sprintf("must be at most %s characters", fe.Param())
+		}
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must contain at most %s items", fe.Param())
+		}
+		return "must be at most " + fe.Param()
+	}
+	return "failed the " + fe.Tag() + " rule"
+}
@@ -0,0 +1,103 @@
+package validate
+
+import (
+	"reflect"
+	"testing"
+)
+
+type status string
+
+func (s status) Valid() bool { return s == "on" || s == "off" }
+
+type Address struct {
+	Country string `json:"country" validate:"country"`
+}
+
+type Base struct {
+	Slug string `json:"slug" validate:"slug"`
+}
+
+type Request struct {
+	Base
+	Price   float64  `json:"price" validate:"positive"`
+	Status  status   `json:"status" validate:"valid"`
+	Address *Address `json:"address" validate:"required"`
+	Secret  string   `json:"-" validate:"required"`
+}
+
+func valid() Request {
+	return Request{
+		Base:    Base{Slug: "summer-sale-2024"},
+		Price:   9.5,
+		Status:  "on",
+		Address: &Address{Country: "de"},
+		Secret:  "x",
+	}
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*Request)
+		want   []FieldError
+	}{
+		{"valid", func(*Request) {}, nil},
+		{"upper-case country", func(r *Request) { r.Address.Country = "DE" }, nil},
+		{"unknown country", func(r *Request) { r.Address.Country = "xx" }, []FieldError{
+			{Field: "address.country", Rule: "country", Message: "must be a two-letter ISO country code"},
+		}},
+		{"zero price", func(r *Request) { r.Price = 0 }, []FieldError{
+			{Field: "price", Rule: "positive", Message: "must be greater than 0"},
+		}},
+		{"negative price", func(r *Request) { r.Price = -1 }, []FieldError{
+			{Field: "price", Rule: "positive", Message: "must be greater than 0"},
+		}},
+		{"invalid enum", func(r *Request) { r.Status = "maybe" }, []FieldError{
+			{Field: "status", Rule: "valid", Message: "is not an accepted value"},
+		}},
+		{"embedded field named without its struct", func(r *Request) { r.Slug = "Summer Sale" }, []FieldError{
+			{Field: "slug", Rule: "slug", Message: "must be lowercase letters and digits separated by hyphens"},
+		}},
+		{"missing nested struct", func(r *Request) { r.Address = nil }, []FieldError{
+			{Field: "address", Rule: "required", Message: "is required"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.mutate(&r)
+			if got := Struct(r); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlug(t *testing.T) {
+	for s, want := range map[string]bool{
+		"shoes":       true,
+		"mens-shoes":  true,
+		"size-10":     true,
+		"":            false,
+		"-shoes":      false,
+		"shoes-":      false,
+		"mens--shoes": false,
+		"Shoes":       false,
+		"mens_shoes":  false,
+		"mens shoes":  false,
+	} {
+		if got := slugRE.MatchString(s); got != want {
+			t.Errorf("%q: got %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestAnonymousStructHasNoPrefix(t *testing.T) {
+	req := struct {
+		Qty int `json:"qty" validate:"min=1"`
+	}{}
+	want := []FieldError{{Field: "qty", Rule: "min", Param: "1", Message: "must be at least 1"}}
+	if got := Struct(req); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}